@@ -0,0 +1,265 @@
+package output
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OverflowPolicy specifies how the async writer behaves when its buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes the logging goroutine wait until there is
+	// a free slot in the buffer.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop discards the log entry if the buffer is full.
+	OverflowDrop
+)
+
+const (
+	// DefaultAsyncBufferSize is the default amount of log entries buffered by the async writer.
+	DefaultAsyncBufferSize = 1024
+	// DefaultDropReportInterval is the default interval of reporting dropped log entries.
+	DefaultDropReportInterval = 10 * time.Second
+
+	// asyncMaxBatch is the maximum amount of entries written at once.
+	asyncMaxBatch = 128
+)
+
+// AsyncWriterOptions allows to set async writer options.
+type AsyncWriterOptions struct {
+	// BufferSize is the amount of log entries buffered, 1024 by default.
+	BufferSize int
+	// Policy specifies what to do when the buffer is full, to block or to drop entries.
+	Policy OverflowPolicy
+	// DropReportInterval sets how often the amount of dropped entries is logged.
+	DropReportInterval time.Duration
+}
+
+type asyncItem struct {
+	data    []byte
+	flushed chan error
+}
+
+// AsyncWriter is an io.WriteCloser that writes into the underlying writer in background,
+// so slow writers don't stall logging goroutines. Entries buffered at the same time are
+// written in batches. Close flushes the buffer and closes the underlying writer.
+type AsyncWriter struct {
+	w   io.Writer
+	opt *AsyncWriterOptions
+
+	// queueMux guards queue against sends after it has been closed.
+	queueMux sync.RWMutex
+	queue    chan *asyncItem
+	closed   bool
+	done     chan struct{}
+
+	errMux sync.Mutex
+	err    error
+
+	dropped  uint64
+	reported uint64
+}
+
+// NewAsyncWriter starts writing into w in background.
+func NewAsyncWriter(w io.Writer, opt *AsyncWriterOptions) *AsyncWriter {
+	opt = checkAsyncWriterOptions(opt)
+
+	aw := &AsyncWriter{
+		w:     w,
+		opt:   opt,
+		queue: make(chan *asyncItem, opt.BufferSize),
+		done:  make(chan struct{}),
+	}
+
+	go aw.writeLoop()
+
+	return aw
+}
+
+func checkAsyncWriterOptions(opt *AsyncWriterOptions) *AsyncWriterOptions {
+	if opt == nil {
+		opt = &AsyncWriterOptions{}
+	}
+
+	if opt.BufferSize <= 0 {
+		opt.BufferSize = DefaultAsyncBufferSize
+	}
+
+	if opt.DropReportInterval <= 0 {
+		opt.DropReportInterval = DefaultDropReportInterval
+	}
+
+	return opt
+}
+
+// Write buffers the data, it's written into the underlying writer in background.
+// Errors of the underlying writer are returned by Flush and Close.
+func (aw *AsyncWriter) Write(p []byte) (int, error) {
+	aw.queueMux.RLock()
+	defer aw.queueMux.RUnlock()
+
+	if aw.closed {
+		return 0, os.ErrClosed
+	}
+
+	// the buffer is reused by the caller, e.g. logrus pools entry buffers
+	item := &asyncItem{
+		data: append([]byte(nil), p...),
+	}
+
+	if aw.opt.Policy == OverflowDrop {
+		select {
+		case aw.queue <- item:
+		default:
+			atomic.AddUint64(&aw.dropped, 1)
+		}
+
+		return len(p), nil
+	}
+
+	aw.queue <- item
+
+	return len(p), nil
+}
+
+// Dropped returns the amount of entries discarded due to a full buffer.
+func (aw *AsyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&aw.dropped)
+}
+
+// Flush waits until the entries buffered so far are written, it returns
+// the last error of the underlying writer.
+func (aw *AsyncWriter) Flush() error {
+	aw.queueMux.RLock()
+	if aw.closed {
+		aw.queueMux.RUnlock()
+		return os.ErrClosed
+	}
+
+	item := &asyncItem{
+		flushed: make(chan error, 1),
+	}
+	aw.queue <- item
+	aw.queueMux.RUnlock()
+
+	return <-item.flushed
+}
+
+// Close writes the buffered entries and closes the underlying writer if it's an io.Closer.
+func (aw *AsyncWriter) Close() error {
+	aw.queueMux.Lock()
+	if aw.closed {
+		aw.queueMux.Unlock()
+		return nil
+	}
+
+	aw.closed = true
+	close(aw.queue)
+	aw.queueMux.Unlock()
+
+	<-aw.done
+
+	aw.reportDropped()
+
+	err := aw.lastErr()
+
+	if closer, ok := aw.w.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+func (aw *AsyncWriter) writeLoop() {
+	defer close(aw.done)
+
+	t := time.NewTicker(aw.opt.DropReportInterval)
+	defer t.Stop()
+
+	var batch bytes.Buffer
+
+	for {
+		select {
+		case item, ok := <-aw.queue:
+			if !ok {
+				return
+			}
+
+			aw.writeBatch(&batch, item)
+		case <-t.C:
+			aw.reportDropped()
+		}
+	}
+}
+
+// writeBatch writes the item along with the items buffered after it.
+func (aw *AsyncWriter) writeBatch(batch *bytes.Buffer, item *asyncItem) {
+	batch.Reset()
+
+	for n := 0; ; n++ {
+		if item.flushed != nil {
+			aw.write(batch)
+			item.flushed <- aw.lastErr()
+		} else {
+			batch.Write(item.data)
+		}
+
+		if n >= asyncMaxBatch {
+			break
+		}
+
+		var ok bool
+
+		select {
+		case item, ok = <-aw.queue:
+		default:
+		}
+
+		if !ok {
+			break
+		}
+	}
+
+	aw.write(batch)
+}
+
+func (aw *AsyncWriter) write(batch *bytes.Buffer) {
+	if batch.Len() == 0 {
+		return
+	}
+
+	if _, err := aw.w.Write(batch.Bytes()); err != nil {
+		aw.errMux.Lock()
+		aw.err = err
+		aw.errMux.Unlock()
+	}
+
+	batch.Reset()
+}
+
+func (aw *AsyncWriter) lastErr() error {
+	aw.errMux.Lock()
+	defer aw.errMux.Unlock()
+
+	return aw.err
+}
+
+// reportDropped logs the amount of entries dropped since the last report.
+func (aw *AsyncWriter) reportDropped() {
+	dropped := atomic.LoadUint64(&aw.dropped)
+	if reported := atomic.SwapUint64(&aw.reported, dropped); dropped > reported {
+		logrus.WithFields(logrus.Fields{
+			"dropped": dropped - reported,
+			"total":   dropped,
+		}).Warningln("log entries dropped, async writer buffer is full")
+	}
+}
@@ -0,0 +1,160 @@
+package output
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingWriter holds writes until it's released.
+type blockingWriter struct {
+	release chan struct{}
+
+	mux    sync.Mutex
+	buf    bytes.Buffer
+	writes int
+	closed bool
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	w.writes++
+
+	return w.buf.Write(p)
+}
+
+func (w *blockingWriter) Close() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	w.closed = true
+
+	return nil
+}
+
+func TestAsyncWriter(t *testing.T) {
+	w := &blockingWriter{
+		release: make(chan struct{}),
+	}
+
+	aw := NewAsyncWriter(w, &AsyncWriterOptions{
+		BufferSize: 16,
+	})
+
+	out := NewOutputter(aw, new(JSONFormatter))
+
+	// entries are buffered while the writer is stalled
+	for i := 0; i < 10; i++ {
+		out.WithField("i", i).Infoln("request done")
+	}
+
+	close(w.release)
+
+	if err := aw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	w.mux.Lock()
+	lines := strings.Count(w.buf.String(), "\n")
+	writes := w.writes
+	w.mux.Unlock()
+
+	if lines != 10 {
+		t.Errorf("expected 10 entries to be written, got %d", lines)
+	} else if writes >= 10 {
+		t.Errorf("expected entries to be written in batches, got %d writes", writes)
+	}
+
+	out.Infoln("last entry")
+
+	if err := out.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(w.buf.String(), "last entry") {
+		t.Error("expected Close to flush the buffer")
+	} else if !w.closed {
+		t.Error("expected Close to close the underlying writer")
+	}
+
+	if _, err := aw.Write([]byte("closed")); err != os.ErrClosed {
+		t.Errorf("expected os.ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestAsyncWriterDrop(t *testing.T) {
+	w := &blockingWriter{
+		release: make(chan struct{}),
+	}
+
+	aw := NewAsyncWriter(w, &AsyncWriterOptions{
+		BufferSize: 4,
+		Policy:     OverflowDrop,
+	})
+
+	out := NewOutputter(aw, new(JSONFormatter))
+
+	for i := 0; i < 100; i++ {
+		out.WithField("i", i).Infoln("request done")
+	}
+
+	close(w.release)
+
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Count(w.buf.String(), "\n")
+	if dropped := aw.Dropped(); dropped == 0 || lines+int(dropped) != 100 {
+		t.Errorf("expected written and dropped entries to add up to 100, got %d and %d", lines, dropped)
+	}
+}
+
+// slowWriter takes a while to write.
+type slowWriter struct {
+	mux sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(50 * time.Millisecond)
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	return w.buf.Write(p)
+}
+
+func TestAsyncWriterFatal(t *testing.T) {
+	w := new(slowWriter)
+
+	out := NewOutputter(NewAsyncWriter(w, nil), new(JSONFormatter))
+
+	var exited bool
+
+	out.(*outputter).logger.ExitFunc = func(code int) {
+		exited = true
+	}
+
+	out.Infoln("before fatal")
+	out.Fatalln("fatal reason")
+
+	if !exited {
+		t.Fatal("expected Fatal to exit")
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if !strings.Contains(w.buf.String(), "before fatal") || !strings.Contains(w.buf.String(), "fatal reason") {
+		t.Errorf("expected entries written before exit, got %q", w.buf.String())
+	}
+}
@@ -8,6 +8,8 @@ import (
 	bugsnagHook "github.com/hatchify/output-bugsnag/hooks/bugsnag"
 	blobHook "github.com/hatchify/output/hooks/blob"
 	debugHook "github.com/hatchify/output/hooks/debug"
+
+	"github.com/sirupsen/logrus"
 )
 
 // NewWrapper will return a wrapper over default logger. This is for compatibility with
@@ -80,8 +82,11 @@ func (l *Logger) addDefaultHooks() {
 	l.out.AddHook(debugHook.NewHook(nil))
 
 	if isTrue(os.Getenv("OUTPUT_BLOB_ENABLED")) {
-		hook, _ := blobHook.NewHook(nil)
-		l.out.AddHook(hook)
+		if hook, err := blobHook.NewHook(nil); err != nil {
+			logrus.WithError(err).Warningln("failed to init blob hook")
+		} else {
+			l.out.AddHook(hook)
+		}
 	}
 
 	if isTrue(os.Getenv("OUTPUT_BUGSNAG_ENABLED")) {
@@ -126,6 +131,7 @@ func (l *Logger) Close() (err error) {
 	}
 
 	l.closed = true
+	closeHooks(l.out.logger.Hooks)
 
 	return l.wc.Close()
 }
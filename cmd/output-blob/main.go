@@ -0,0 +1,370 @@
+// Command output-blob retrieves blobs uploaded by the blob hook, it is configured
+// by the same OUTPUT_ENV and OUTPUT_BLOB_* env variables as the hook itself.
+//
+// Usage:
+//
+//	output-blob get [-raw] [-o file] <blob>...
+//	output-blob ls [-from time] [-to time]
+//	output-blob search [-from time] [-to time] [-level level] [-msg text] [field=value]...
+//	output-blob open <blob>
+//	output-blob serve [-addr addr]
+//
+// Blobs could be referenced by keys, URLs or bare ULIDs of the env.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	blobHook "github.com/hatchify/output/hooks/blob"
+)
+
+const usage = `Usage: output-blob [-env env] <command> [arguments]
+
+Commands:
+  get [-raw] [-o file] <blob>...   print blobs, JSON and HTML are pretty-printed
+  ls [-from time] [-to time]       list blobs logged within the time range
+  search [-from time] [-to time] [-level level] [-msg text] [field=value]...
+                                   search the blob index for blobs of log entries
+  open <blob>                      open the blob in the default application
+  serve [-addr addr]               serve a web viewer of the blobs
+
+Blobs could be referenced by keys, URLs or bare ULIDs of the env. Times are
+RFC 3339 timestamps, dates or durations back from now, e.g. 2h.
+
+The blob store is configured by OUTPUT_ENV and OUTPUT_BLOB_* env variables,
+OUTPUT_BLOB_ENCRYPTION_KEY is used to decrypt encrypted blobs.
+`
+
+type app struct {
+	opt   *blobHook.HookOptions
+	store blobHook.BlobStore
+	keys  blobHook.Keyring
+}
+
+func main() {
+	flags := flag.NewFlagSet("output-blob", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	env := flags.String("env", "", "env of the blobs, OUTPUT_ENV by default")
+	flags.Parse(os.Args[1:]) //nolint:errcheck
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	a, err := newApp(hookOptions(*env))
+	if err != nil {
+		fatal(err)
+	}
+
+	cmd, args := flags.Arg(0), flags.Args()[1:]
+
+	switch cmd {
+	case "get":
+		err = a.get(args)
+	case "ls", "list":
+		err = a.list(args)
+	case "search":
+		err = a.search(args)
+	case "open":
+		err = a.open(args)
+	case "serve":
+		err = a.serve(args)
+	default:
+		fmt.Fprintf(os.Stderr, "output-blob: unknown command %q\n\n", cmd)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// hookOptions returns options of the hook logging into the env, OUTPUT_ENV if env is empty.
+// The env is set before the defaults, as the default blob store depends on it.
+func hookOptions(env string) *blobHook.HookOptions {
+	return blobHook.FillHookOptions(&blobHook.HookOptions{
+		Env: env,
+	})
+}
+
+func newApp(opt *blobHook.HookOptions) (*app, error) {
+	store, err := blobHook.NewBlobStore(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &app{
+		opt:   opt,
+		store: store,
+		keys:  blobHook.Keyring{},
+	}
+
+	if envKey := os.Getenv("OUTPUT_BLOB_ENCRYPTION_KEY"); len(envKey) > 0 {
+		key, err := blobHook.ParseEncryptionKey(envKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OUTPUT_BLOB_ENCRYPTION_KEY: %w", err)
+		}
+
+		keyID := os.Getenv("OUTPUT_BLOB_ENCRYPTION_KEY_ID")
+		if len(keyID) == 0 {
+			keyID = blobHook.EncryptionKeyID(key)
+		}
+
+		a.keys[keyID] = key
+	}
+
+	return a, nil
+}
+
+func (a *app) get(args []string) error {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	raw := flags.Bool("raw", false, "print blobs as is, without pretty-printing")
+	outPath := flags.String("o", "", "write the blob into the file")
+	flags.Parse(args) //nolint:errcheck
+
+	if flags.NArg() == 0 {
+		return errors.New("no blobs specified")
+	}
+
+	out := os.Stdout
+
+	if len(*outPath) > 0 {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		out = f
+	}
+
+	for _, ref := range flags.Args() {
+		blob, err := a.fetch(ref)
+		if err != nil {
+			return err
+		}
+
+		data := blob.Data
+		if !*raw {
+			data = blobHook.PrettyPrint(blob)
+		}
+
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *app) list(args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ExitOnError)
+	fromFlag := flags.String("from", "24h", "list blobs logged since the time")
+	toFlag := flags.String("to", "", "list blobs logged until the time")
+	flags.Parse(args) //nolint:errcheck
+
+	from, err := parseTime(*fromFlag)
+	if err != nil {
+		return err
+	}
+
+	to, err := parseTime(*toFlag)
+	if err != nil {
+		return err
+	}
+
+	blobs, err := blobHook.ListBlobs(a.store, a.opt.Env, from, to)
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range blobs {
+		ts, _ := blobHook.BlobTime(spec.Key)
+
+		contentType := spec.ContentType
+		if blobHook.IsEncrypted(spec.Meta) {
+			contentType, _ = blobHook.PlaintextAttributes(spec.Meta)
+		}
+
+		fmt.Printf("%s  %s  %8d  %s\n", ts.Local().Format("2006-01-02 15:04:05.000"), spec.Key, spec.Size, contentType)
+	}
+
+	return nil
+}
+
+func (a *app) search(args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	fromFlag := flags.String("from", "24h", "search blobs logged since the time")
+	toFlag := flags.String("to", "", "search blobs logged until the time")
+	level := flags.String("level", "", "level of the log entries")
+	msg := flags.String("msg", "", "text contained in messages of the log entries")
+	limit := flags.Int("limit", 0, "maximum amount of blobs found")
+	flags.Parse(args) //nolint:errcheck
+
+	q := &blobHook.IndexQuery{
+		Level:   *level,
+		Message: *msg,
+		Limit:   *limit,
+		Fields:  make(map[string]string),
+	}
+
+	var err error
+
+	if q.From, err = parseTime(*fromFlag); err != nil {
+		return err
+	} else if q.To, err = parseTime(*toFlag); err != nil {
+		return err
+	}
+
+	for _, arg := range flags.Args() {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("field condition must be field=value: %s", arg)
+		}
+
+		q.Fields[parts[0]] = parts[1]
+	}
+
+	records, err := blobHook.SearchIndex(a.store, a.opt.Env, q, a.keys)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		fields := make([]string, 0, len(rec.Fields))
+		for name, value := range rec.Fields {
+			fields = append(fields, name+"="+value)
+		}
+
+		sort.Strings(fields)
+
+		fmt.Printf("%s  %-7s  %s  msg=%q %s\n",
+			rec.Time.Local().Format("2006-01-02 15:04:05.000"),
+			rec.Level, rec.Key, rec.Message, strings.Join(fields, " "))
+	}
+
+	return nil
+}
+
+func (a *app) open(args []string) error {
+	if len(args) != 1 {
+		return errors.New("exactly one blob must be specified")
+	}
+
+	key := blobHook.BlobKey(a.opt.Env, args[0])
+
+	blob, err := a.fetch(key)
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "output-blob")
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(dir, filepath.Base(key)+fileExt(blob.ContentType))
+	if err := ioutil.WriteFile(name, blob.Data, 0600); err != nil {
+		return err
+	}
+
+	fmt.Println(name)
+
+	return openFile(name)
+}
+
+func (a *app) serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8089", "address to listen on")
+	flags.Parse(args) //nolint:errcheck
+
+	handler := blobHook.NewViewerHandler(a.store, &blobHook.ViewerOptions{
+		Env:  a.opt.Env,
+		Keys: a.keys,
+	})
+
+	fmt.Fprintf(os.Stderr, "serving blobs on http://%s, set OUTPUT_BLOB_VIEWER_URL to link them in logs\n", *addr)
+
+	return http.ListenAndServe(*addr, handler)
+}
+
+func (a *app) fetch(ref string) (*blobHook.Blob, error) {
+	key := blobHook.BlobKey(a.opt.Env, ref)
+
+	spec, err := a.store.GetObject(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob %s: %w", key, err)
+	}
+
+	blob, err := blobHook.DecodeBlob(spec, a.keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode blob %s: %w", key, err)
+	}
+
+	return blob, nil
+}
+
+// parseTime parses RFC 3339 timestamps, dates and durations back from now.
+func parseTime(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if ts, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return ts, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("failed to parse time: %s", s)
+}
+
+func fileExt(contentType string) string {
+	switch {
+	case strings.Contains(contentType, "json"):
+		return ".json"
+	case strings.HasPrefix(contentType, "text/html"):
+		return ".html"
+	case strings.Contains(contentType, "xml"):
+		return ".xml"
+	case strings.HasPrefix(contentType, "text/"):
+		return ".txt"
+	default:
+		return ""
+	}
+}
+
+func openFile(name string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", name).Run()
+	case "windows":
+		return exec.Command("cmd", "/c", "start", "", name).Run()
+	default:
+		return exec.Command("xdg-open", name).Run()
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "output-blob:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	blobHook "github.com/hatchify/output/hooks/blob"
+)
+
+func TestFetch(t *testing.T) {
+	const id = "01E5Z6B2Z7KJ8V5Q6M3R9X0T1A"
+
+	data := []byte(`{"status":"ok"}`)
+
+	store := blobHook.NewMemoryStore(t.Name())
+	if _, err := store.PutObject("test/"+id, bytes.NewReader(data), &blobHook.PutOptions{
+		ContentType: "application/json",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := newApp(&blobHook.HookOptions{
+		Env:          "test",
+		BlobStoreURL: "mem://" + t.Name(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{
+		id,
+		"test/" + id,
+		"mem://" + t.Name() + "/test/" + id,
+		"https://blobs.example.com/" + id,
+		"https://viewer.example.com/test/" + id,
+	} {
+		blob, err := a.fetch(ref)
+		if err != nil {
+			t.Errorf("failed to fetch %q: %v", ref, err)
+			continue
+		}
+
+		if !bytes.Equal(blob.Data, data) {
+			t.Errorf("unexpected blob of %q: %s", ref, blob.Data)
+		}
+	}
+
+	for _, ref := range []string{
+		"https://blobs.example.com/01E5Z6B2Z7KJ8V5Q6M3R9X0T1B",
+		"https://bucket.s3.amazonaws.com/prod/" + id + "?X-Amz-Expires=3600",
+		"https://viewer.example.com/prod/" + id,
+	} {
+		if _, err := a.fetch(ref); err == nil {
+			t.Errorf("expected error fetching missing blob %q", ref)
+		}
+	}
+
+	// blobs of other envs are referenced by URLs with the env
+	staging, err := newApp(&blobHook.HookOptions{
+		Env:          "staging",
+		BlobStoreURL: "mem://" + t.Name(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{
+		"test/" + id,
+		"https://bucket.s3.amazonaws.com/test/" + id + "?X-Amz-Expires=3600",
+		"https://viewer.example.com/test/" + id,
+	} {
+		if _, err := staging.fetch(ref); err != nil {
+			t.Errorf("failed to fetch %q from staging: %v", ref, err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "output-blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "blob.json")
+	if err := a.get([]string{"-raw", "-o", name, "https://blobs.example.com/" + id}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("unexpected blob written by get: %s", got)
+	}
+}
+
+func TestHookOptions(t *testing.T) {
+	for _, name := range []string{"OUTPUT_ENV", "OUTPUT_BLOB_STORE_URL"} {
+		if v, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, v)
+		}
+
+		os.Unsetenv(name)
+	}
+
+	opt := hookOptions("prod")
+	if opt.Env != "prod" || len(opt.BlobStoreURL) > 0 {
+		t.Errorf("expected the remote store of the prod env, got %s env with store %q", opt.Env, opt.BlobStoreURL)
+	}
+
+	opt = hookOptions("")
+	if opt.Env != "local" || !strings.HasPrefix(opt.BlobStoreURL, "file://") {
+		t.Errorf("expected the local store of the local env, got %s env with store %q", opt.Env, opt.BlobStoreURL)
+	}
+}
@@ -0,0 +1,93 @@
+package output
+
+import (
+	"context"
+	"sync"
+)
+
+// outputterKey is the context key of the outputter, it's unexported so only
+// NewContext and FromContext access the value.
+type outputterKey struct{}
+
+// NewContext returns a copy of ctx carrying the outputter, e.g. an outputter
+// with request fields, so it could be retrieved with FromContext down the call stack.
+func NewContext(ctx context.Context, out Outputter) context.Context {
+	return context.WithValue(ctx, outputterKey{}, out)
+}
+
+// FromContext returns the outputter stored in ctx by NewContext,
+// or the default outputter if there is none.
+func FromContext(ctx context.Context) Outputter {
+	if ctx != nil {
+		if out, ok := ctx.Value(outputterKey{}).(Outputter); ok && out != nil {
+			return out
+		}
+	}
+
+	return defaultOut
+}
+
+// ContextExtractor returns fields extracted from the context, e.g. request and trace IDs.
+// It returns nil if the context has none of the values.
+type ContextExtractor func(ctx context.Context) Fields
+
+//nolint:gochecknoglobals
+var globalExtractors = new(contextExtractors)
+
+// RegisterContextExtractor registers the extractor for all outputters. Entries get
+// extracted fields when a context is attached with WithContext, so fields are available
+// to formatters and hooks. Fields already set on the entry are not overridden.
+func RegisterContextExtractor(fn ContextExtractor) {
+	globalExtractors.Add(fn)
+}
+
+// contextExtractors is a list of extractors shared by outputter copies.
+type contextExtractors struct {
+	mux sync.RWMutex
+	fns []ContextExtractor
+}
+
+func (x *contextExtractors) Add(fn ContextExtractor) {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+
+	x.fns = append(x.fns, fn)
+}
+
+// Extract adds the fields extracted from ctx to fields, skipping the fields that are already set.
+func (x *contextExtractors) Extract(ctx context.Context, fields Fields) Fields {
+	if x == nil {
+		return fields
+	}
+
+	x.mux.RLock()
+	defer x.mux.RUnlock()
+
+	for _, fn := range x.fns {
+		for key, v := range fn(ctx) {
+			if _, ok := fields[key]; ok {
+				continue
+			}
+
+			if fields == nil {
+				fields = make(Fields)
+			}
+
+			fields[key] = v
+		}
+	}
+
+	return fields
+}
+
+// extractContextFields returns the fields extracted by the outputter extractors,
+// which take precedence, and the global ones.
+func (out *outputter) extractContextFields(ctx context.Context) Fields {
+	if ctx == nil {
+		return nil
+	}
+
+	fields := out.extractors.Extract(ctx, nil)
+
+	return globalExtractors.Extract(ctx, fields)
+}
@@ -0,0 +1,72 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestContext(t *testing.T) {
+	if FromContext(context.Background()) != defaultOut {
+		t.Error("expected default outputter without outputter in context")
+	}
+
+	var buf bytes.Buffer
+
+	out := NewOutputter(&buf, &TextFormatter{
+		DisableTimestamp: true,
+	}).WithField("request_id", "abc")
+
+	ctx := NewContext(context.Background(), out)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	FromContext(ctx).Infoln("handled")
+
+	if !strings.Contains(buf.String(), "request_id=abc") {
+		t.Errorf("expected request fields in output, got %q", buf.String())
+	}
+}
+
+type ctxKey string
+
+func TestContextExtractors(t *testing.T) {
+	RegisterContextExtractor(func(ctx context.Context) Fields {
+		if id, ok := ctx.Value(ctxKey("trace")).(string); ok {
+			return Fields{"trace_id": id, "tenant": "global"}
+		}
+
+		return nil
+	})
+
+	var buf bytes.Buffer
+
+	hook := new(countingHook)
+	out := NewOutputter(&buf, new(JSONFormatter), hook)
+	out.(ContextExtractorAdder).AddContextExtractor(func(ctx context.Context) Fields {
+		return Fields{"tenant": "acme"}
+	})
+
+	ctx := context.WithValue(context.Background(), ctxKey("trace"), "t1")
+
+	out.WithField("trace_id", "explicit").WithContext(ctx).Infoln("first")
+	out.WithContext(ctx).Infoln("second")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+
+	if !strings.Contains(lines[0], `"trace_id":"explicit"`) || !strings.Contains(lines[0], `"tenant":"acme"`) {
+		t.Errorf("expected explicit fields kept and outputter extractor preferred, got %s", lines[0])
+	}
+
+	if !strings.Contains(lines[1], `"trace_id":"t1"`) {
+		t.Errorf("expected extracted trace ID, got %s", lines[1])
+	}
+
+	if hook.fired != 2 {
+		t.Errorf("expected hook to fire twice, fired %d times", hook.fired)
+	}
+}
@@ -0,0 +1,454 @@
+package output
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FileWriterOptions allows to set file writer options.
+type FileWriterOptions struct {
+	// MaxSize is the size in bytes the file is rotated at, it's not rotated by size if zero.
+	MaxSize int64
+	// RotateEvery rotates the file periodically, e.g. every 24 hours. The periods
+	// are aligned to the zero time, so daily files are rotated at midnight UTC.
+	RotateEvery time.Duration
+	// MaxBackups is the amount of rotated files kept, all files are kept if zero.
+	MaxBackups int
+	// MaxAge is how long rotated files are kept, they are kept forever if zero.
+	MaxAge time.Duration
+	// Compress makes rotated files gzipped.
+	Compress bool
+	// ReopenOnSIGHUP reopens the file when the process gets SIGHUP, so the file
+	// could be rotated by an external tool such as logrotate.
+	ReopenOnSIGHUP bool
+	// FileMode is the mode new files are created with, 0644 by default.
+	FileMode os.FileMode
+}
+
+// FileWriter is an io.WriteCloser writing into a file, which is rotated by size and time.
+// Rotated files are renamed to <name>-<UTC time>.<ext> and optionally compressed.
+type FileWriter struct {
+	filename string
+	opt      *FileWriterOptions
+
+	mux sync.Mutex
+	// file is nil if it has failed to be opened, it's opened again on the next write
+	file       *os.File
+	size       int64
+	nextRotate time.Time
+	closed     bool
+	// rotateErr is the error of the last failed rotation, it's reported once until
+	// a rotation succeeds
+	rotateErr error
+	// lastBackup is the time in the name of the last rotated file
+	lastBackup time.Time
+
+	mill     chan struct{}
+	millDone chan struct{}
+	sighup   chan os.Signal
+}
+
+// renameFile renames files, it's replaced by tests to simulate rename failures.
+//
+//nolint:gochecknoglobals
+var renameFile = os.Rename
+
+// backupTimeFormat is the time format of rotated file names, it's sortable and safe for file systems.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// NewFileWriter opens the file for appending, creating it and its dir if needed.
+func NewFileWriter(filename string, opt *FileWriterOptions) (*FileWriter, error) {
+	w := &FileWriter{
+		filename: filename,
+		opt:      checkFileWriterOptions(opt),
+		mill:     make(chan struct{}, 1),
+		millDone: make(chan struct{}),
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	go w.millLoop()
+
+	if w.opt.ReopenOnSIGHUP {
+		w.sighup = make(chan os.Signal, 1)
+		signal.Notify(w.sighup, syscall.SIGHUP)
+
+		go w.reopenLoop(w.sighup)
+	}
+
+	// prune the files left by the previous runs
+	w.millNow()
+
+	return w, nil
+}
+
+func checkFileWriterOptions(opt *FileWriterOptions) *FileWriterOptions {
+	if opt == nil {
+		opt = &FileWriterOptions{}
+	}
+
+	if opt.FileMode == 0 {
+		opt.FileMode = 0644
+	}
+
+	return opt
+}
+
+// Write writes into the file, rotating it first if the size limit
+// would be exceeded or the rotation period has passed.
+func (w *FileWriter) Write(p []byte) (n int, err error) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	if w.file != nil && w.shouldRotate(int64(len(p))) {
+		// writing continues into the current file if it can't be rotated,
+		// the rotation is retried on the next write
+		failing := w.rotateErr != nil
+		if err := w.rotate(); err != nil && !failing {
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file, %v\n", err)
+		}
+	}
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = w.file.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+// Rotate renames the current file to a backup name and opens a new file.
+func (w *FileWriter) Rotate() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	return w.rotate()
+}
+
+// Reopen closes and opens the file again, it should be called after the file
+// has been moved by an external tool, so writing continues into a new file.
+func (w *FileWriter) Reopen() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	if w.file != nil {
+		err := w.file.Close()
+		w.file = nil
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.open()
+}
+
+// Close closes the file and waits for the rotated files to be compressed.
+func (w *FileWriter) Close() error {
+	w.mux.Lock()
+	if w.closed {
+		w.mux.Unlock()
+		return nil
+	}
+
+	w.closed = true
+
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+	}
+	w.mux.Unlock()
+
+	if w.sighup != nil {
+		signal.Stop(w.sighup)
+		close(w.sighup)
+	}
+
+	close(w.mill)
+	<-w.millDone
+
+	return err
+}
+
+func (w *FileWriter) shouldRotate(writeSize int64) bool {
+	if w.opt.MaxSize > 0 && w.size > 0 && w.size+writeSize > w.opt.MaxSize {
+		return true
+	}
+
+	return !w.nextRotate.IsZero() && !time.Now().Before(w.nextRotate)
+}
+
+func (w *FileWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.filename), 0755); err != nil {
+		return fmt.Errorf("failed to create log dir: %w", err)
+	}
+
+	f, err := os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, w.opt.FileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	w.file = f
+	w.size = info.Size()
+
+	if w.opt.RotateEvery > 0 {
+		w.nextRotate = time.Now().Truncate(w.opt.RotateEvery).Add(w.opt.RotateEvery)
+	}
+
+	return nil
+}
+
+// rotate renames the file and opens a new one. If the file can't be renamed,
+// it's opened again, so writing continues into it. If it can't be opened,
+// it's opened on the next write.
+func (w *FileWriter) rotate() (err error) {
+	defer func() {
+		w.rotateErr = err
+	}()
+
+	if w.file != nil {
+		closeErr := w.file.Close()
+		w.file = nil
+
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+
+	renameErr := renameFile(w.filename, w.backupName(time.Now()))
+	if renameErr != nil && os.IsNotExist(renameErr) {
+		renameErr = nil
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	} else if renameErr != nil {
+		return fmt.Errorf("failed to rotate log file: %w", renameErr)
+	}
+
+	w.millNow()
+
+	return nil
+}
+
+// backupName returns a name of the rotated file that is not taken yet. Names of files
+// rotated within a millisecond are ordered, even if the previous ones have been pruned.
+func (w *FileWriter) backupName(ts time.Time) string {
+	dir, prefix, ext := w.backupParts()
+
+	ts = ts.Truncate(time.Millisecond)
+	if !ts.After(w.lastBackup) {
+		ts = w.lastBackup.Add(time.Millisecond)
+	}
+
+	for {
+		name := filepath.Join(dir, prefix+ts.UTC().Format(backupTimeFormat)+ext)
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			if _, err := os.Stat(name + ".gz"); os.IsNotExist(err) {
+				w.lastBackup = ts
+				return name
+			}
+		}
+
+		ts = ts.Add(time.Millisecond)
+	}
+}
+
+// backupParts returns the dir, the name prefix and the extension of rotated files.
+func (w *FileWriter) backupParts() (dir, prefix, ext string) {
+	dir = filepath.Dir(w.filename)
+	base := filepath.Base(w.filename)
+	ext = filepath.Ext(base)
+	prefix = strings.TrimSuffix(base, ext) + "-"
+
+	return dir, prefix, ext
+}
+
+// millNow triggers pruning and compression of rotated files in background.
+func (w *FileWriter) millNow() {
+	select {
+	case w.mill <- struct{}{}:
+	default:
+	}
+}
+
+func (w *FileWriter) millLoop() {
+	defer close(w.millDone)
+
+	for range w.mill {
+		if err := w.millRun(); err != nil {
+			logrus.WithError(err).WithField("file", w.filename).Warningln("failed to process rotated log files")
+		}
+	}
+}
+
+type logBackup struct {
+	name string
+	ts   time.Time
+}
+
+// millRun removes rotated files exceeding MaxBackups and MaxAge, and compresses the rest.
+func (w *FileWriter) millRun() error {
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+
+	var keep []logBackup
+
+	for i, b := range backups {
+		expired := w.opt.MaxAge > 0 && time.Since(b.ts) > w.opt.MaxAge
+		if expired || w.opt.MaxBackups > 0 && i >= w.opt.MaxBackups {
+			if err := os.Remove(b.name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			continue
+		}
+
+		keep = append(keep, b)
+	}
+
+	if !w.opt.Compress {
+		return nil
+	}
+
+	for _, b := range keep {
+		if !strings.HasSuffix(b.name, ".gz") {
+			if err := gzipFile(b.name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// backups lists rotated files, the newest first.
+func (w *FileWriter) backups() ([]logBackup, error) {
+	dir, prefix, ext := w.backupParts()
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []logBackup
+
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		tsText := strings.TrimPrefix(name, prefix)
+		tsText = strings.TrimSuffix(tsText, ".gz")
+
+		if !strings.HasSuffix(tsText, ext) {
+			continue
+		}
+
+		ts, err := time.Parse(backupTimeFormat, strings.TrimSuffix(tsText, ext))
+		if err != nil {
+			continue
+		}
+
+		backups = append(backups, logBackup{
+			name: filepath.Join(dir, name),
+			ts:   ts,
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ts.After(backups[j].ts)
+	})
+
+	return backups, nil
+}
+
+func (w *FileWriter) reopenLoop(sighup chan os.Signal) {
+	for range sighup {
+		if err := w.Reopen(); err != nil && err != os.ErrClosed {
+			logrus.WithError(err).WithField("file", w.filename).Warningln("failed to reopen log file")
+		}
+	}
+}
+
+// gzipFile compresses the file into <name>.gz and removes it.
+func gzipFile(name string) (err error) {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(name+".gz.tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(dst.Name())
+		}
+	}()
+
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err != nil {
+		return err
+	} else if err = zw.Close(); err != nil {
+		return err
+	} else if err = dst.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(dst.Name(), name+".gz"); err != nil {
+		return err
+	}
+
+	src.Close()
+
+	return os.Remove(name)
+}
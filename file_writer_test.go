@@ -0,0 +1,164 @@
+package output
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output-file-writer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "logs", "app.log")
+
+	w, err := NewFileWriter(filename, &FileWriterOptions{
+		MaxSize:    100,
+		MaxBackups: 2,
+		Compress:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := NewOutputter(w, new(JSONFormatter))
+
+	for i := 0; i < 10; i++ {
+		out.WithField("i", i).Infoln("this line is long enough to rotate the log file")
+	}
+
+	if err := out.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(data), `"i":9`) {
+		t.Errorf("expected the last entry in the log file, got %q", data)
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "logs", "app-*.log.gz"))
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 compressed backups, got %v", backups)
+	}
+
+	f, err := os.Open(backups[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ioutil.ReadAll(zr); !strings.Contains(string(data), `"i":8`) {
+		t.Errorf("expected the previous entry in the newest backup, got %q", data)
+	}
+
+	if _, err := w.Write([]byte("closed")); err != os.ErrClosed {
+		t.Errorf("expected os.ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestFileWriterReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output-file-writer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+
+	w, err := NewFileWriter(filename, &FileWriterOptions{
+		RotateEvery: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.Write([]byte("before logrotate\n"))
+
+	// logrotate moves the file, then asks the process to reopen it
+	if err := os.Rename(filename, filename+".1"); err != nil {
+		t.Fatal(err)
+	} else if err := w.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+
+	w.Write([]byte("after logrotate\n"))
+
+	if data, _ := ioutil.ReadFile(filename + ".1"); string(data) != "before logrotate\n" {
+		t.Errorf("unexpected rotated file contents: %q", data)
+	}
+
+	if data, _ := ioutil.ReadFile(filename); string(data) != "after logrotate\n" {
+		t.Errorf("unexpected log file contents: %q", data)
+	}
+}
+
+func TestFileWriterRotateFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output-file-writer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+
+	w, err := NewFileWriter(filename, &FileWriterOptions{
+		MaxSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	defer func() {
+		renameFile = os.Rename
+	}()
+
+	renameFile = func(from, to string) error {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrPermission}
+	}
+
+	for _, line := range []string{"first line\n", "second line\n"} {
+		if n, err := w.Write([]byte(line)); err != nil || n != len(line) {
+			t.Fatalf("expected the line written despite failed rotation, got %d, %v", n, err)
+		}
+	}
+
+	if err := w.Rotate(); err == nil {
+		t.Error("expected rotation error")
+	}
+
+	renameFile = os.Rename
+
+	if _, err := w.Write([]byte("third line\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ioutil.ReadFile(filename); string(data) != "third line\n" {
+		t.Errorf("expected the file rotated once renaming works, got %q", data)
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "app-*.log"))
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 backup, got %v", backups)
+	}
+
+	if data, _ := ioutil.ReadFile(backups[0]); string(data) != "first line\nsecond line\n" {
+		t.Errorf("expected lines written during failed rotations in the backup, got %q", data)
+	}
+}
@@ -10,11 +10,12 @@ require (
 	github.com/gofrs/uuid v3.2.0+incompatible // indirect
 	github.com/hatchify/output-bugsnag v1.0.1
 	github.com/kardianos/osext v0.0.0-20190222173326-2bc1f35cddc0 // indirect
+	github.com/klauspost/compress v1.11.13
 	github.com/kr/pretty v0.2.0 // indirect
 	github.com/oklog/ulid v1.3.1
 	github.com/sirupsen/logrus v1.4.2
 	github.com/xlab/closer v0.0.0-20190328110542-03326addb7c2
-	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e // indirect
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
 )
 
 // *** Separate Local Deps *** \\
@@ -0,0 +1,79 @@
+package blob
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/oklog/ulid"
+)
+
+// DecodeBlob reads the object body, decrypting and decompressing it if needed,
+// and returns the blob as it has been logged. The body is closed.
+func DecodeBlob(spec *ObjectSpec, keys Keyring) (*Blob, error) {
+	defer spec.Body.Close()
+
+	r := spec.Body
+	contentType, contentEncoding := spec.ContentType, spec.ContentEncoding
+
+	if IsEncrypted(spec.Meta) {
+		dr, err := NewDecryptingReader(r, spec.Key, spec.Meta, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		r = ioutil.NopCloser(dr)
+		contentType, contentEncoding = PlaintextAttributes(spec.Meta)
+	}
+
+	dr, err := NewDecompressingReader(r, contentEncoding)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	data, err := ioutil.ReadAll(dr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(contentType) == 0 {
+		contentType = DetectContentType(data)
+	}
+
+	return &Blob{
+		Data:        data,
+		ContentType: contentType,
+	}, nil
+}
+
+// BlobKey returns the object key of a blob referenced in a log entry, the reference
+// could be a key, a blob URL including presigned ones, or a bare blob ID of the env.
+// HTTP(S) URLs with a bare blob ID path are resolved to the key of the blob in the env.
+func BlobKey(env, ref string) string {
+	if u, err := url.Parse(ref); err == nil && len(u.Scheme) > 0 {
+		if u.Scheme == "http" || u.Scheme == "https" {
+			// blob URLs of HTTP stores have the blob ID path, which is a key of the env,
+			// S3 and viewer URLs have the env in the path
+			if id := strings.Trim(u.Path, "/"); isBlobID(id) {
+				return path.Join(env, id)
+			}
+		}
+
+		ref = strings.TrimPrefix(u.Host+u.Path, "/")
+	}
+
+	ref = strings.Trim(ref, "/")
+
+	if dir := path.Dir(ref); dir != "." {
+		return path.Join(path.Base(dir), path.Base(ref))
+	}
+
+	return path.Join(env, ref)
+}
+
+func isBlobID(s string) bool {
+	_, err := ulid.ParseStrict(s)
+	return err == nil
+}
@@ -0,0 +1,87 @@
+package blob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// KeyMode specifies how object keys of the blobs are generated.
+type KeyMode int
+
+const (
+	// KeyModeULID gives each blob a new ULID, see NewBlobID.
+	KeyModeULID KeyMode = iota
+	// KeyModeContentHash derives the key from SHA-256 of the blob payload,
+	// so identical blobs are stored once and share the same URL.
+	KeyModeContentHash
+)
+
+// ParseKeyMode takes a string key mode name and returns the KeyMode constant.
+func ParseKeyMode(name string) (KeyMode, bool) {
+	switch strings.ToLower(name) {
+	case "ulid":
+		return KeyModeULID, true
+	case "sha256", "hash", "content-hash":
+		return KeyModeContentHash, true
+	}
+
+	return KeyModeULID, false
+}
+
+// DefaultDedupCacheSize is the default amount of recently uploaded
+// content-addressed blobs remembered by the hook.
+const DefaultDedupCacheSize = 1024
+
+// contentHash returns hex encoded SHA-256 of the payload. If the secret is set,
+// HMAC-SHA256 is used instead, so the key won't reveal encrypted contents.
+func contentHash(payload, secret []byte) string {
+	if len(secret) > 0 {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(payload)
+
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	sum := sha256.Sum256(payload)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// isUploaded checks whether the content-addressed blob has been stored already
+// and won't expire much earlier than the new copy would.
+func (h *hook) isUploaded(job *uploadJob) bool {
+	if expires, ok := h.dedupCache.Get(job.key); ok {
+		return h.isFresh(expires.(time.Time), job.opt.Expires)
+	}
+
+	spec, err := h.store.HeadObject(job.key)
+	if err == ErrNotFound {
+		return false
+	} else if err != nil {
+		logrus.WithError(err).WithField("key", job.key).Debugln("failed to check blob existence")
+		return false
+	}
+
+	h.dedupCache.Add(job.key, spec.Expires)
+
+	return h.isFresh(spec.Expires, job.opt.Expires)
+}
+
+// isFresh reports whether an object that expires at stored time could be referenced
+// by an entry that wants it to live until the wanted time. The object is uploaded again
+// if it has less than a half of the retention TTL left.
+func (h *hook) isFresh(stored, wanted time.Time) bool {
+	switch {
+	case stored.IsZero():
+		return true
+	case wanted.IsZero():
+		return false
+	}
+
+	return !stored.Before(wanted.Add(-h.opt.BlobRetentionTTL / 2))
+}
@@ -0,0 +1,145 @@
+package blob
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Blob is a blob payload with an explicitly specified content type, e.g.
+//
+//	out.WithField("blob", blob.Blob{Data: body, ContentType: "text/html"})
+//
+// Blobs provided without content type are sniffed using DetectContentType.
+type Blob struct {
+	Data        []byte
+	ContentType string
+}
+
+// Supported blob compression algorithms, the names match Content-Encoding values.
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// DetectContentType returns MIME type of the data, in addition to
+// http.DetectContentType it recognizes JSON documents.
+func DetectContentType(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return "application/json"
+	}
+
+	return http.DetectContentType(data)
+}
+
+// compress encodes data using the compression algorithm.
+func compress(compression string, data []byte) ([]byte, error) {
+	if compression == CompressionNone {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	if err := compressTo(compression, &buf, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// compressTo streams data from the reader into the writer encoding it
+// using the compression algorithm.
+func compressTo(compression string, dst io.Writer, r io.Reader) error {
+	var w io.WriteCloser
+
+	switch compression {
+	case CompressionGzip:
+		w = gzip.NewWriter(dst)
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(dst)
+		if err != nil {
+			return err
+		}
+
+		w = zw
+	default:
+		return fmt.Errorf("unsupported blob compression: %s", compression)
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// NewDecompressingReader returns a reader that decodes data compressed
+// with the algorithm specified by Content-Encoding value.
+func NewDecompressingReader(r io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	switch strings.ToLower(contentEncoding) {
+	case CompressionNone, "identity":
+		return ioutil.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported blob content encoding: %s", contentEncoding)
+	}
+}
+
+// isCompressedContentType reports whether the data of the type is
+// already compressed, so it is not worth compressing it again.
+func isCompressedContentType(contentType string) bool {
+	switch {
+	case strings.HasPrefix(contentType, "image/"),
+		strings.HasPrefix(contentType, "audio/"),
+		strings.HasPrefix(contentType, "video/"),
+		strings.HasPrefix(contentType, "font/woff"),
+		contentType == "application/x-gzip",
+		contentType == "application/zip",
+		contentType == "application/wasm":
+		return true
+	}
+
+	return false
+}
+
+func isValidCompression(compression string) bool {
+	switch compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return true
+	}
+
+	return false
+}
+
+// metaValue makes the value safe to be sent as object metadata,
+// which is transferred in HTTP headers, so only printable ASCII is kept.
+func metaValue(v string, limit int) string {
+	if len(v) > limit {
+		v = v[:limit]
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return '?'
+		}
+
+		return r
+	}, v)
+}
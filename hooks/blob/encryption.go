@@ -0,0 +1,207 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Keyring maps encryption key IDs to the keys, it is used to open encrypted blobs.
+type Keyring map[string][]byte
+
+// EncryptionAlgorithm is the envelope encryption algorithm name stored in object metadata.
+// The object key is authenticated along with the blob, so blobs can't be swapped between keys.
+const EncryptionAlgorithm = "AES-GCM-ENVELOPE-2"
+
+// Metadata keys set on encrypted objects.
+const (
+	metaEncAlgorithm       = "enc-alg"
+	metaEncKeyID           = "enc-key-id"
+	metaEncWrappedKey      = "enc-wrapped-key"
+	metaEncContentType     = "enc-content-type"
+	metaEncContentEncoding = "enc-content-encoding"
+)
+
+// dataKeySize is the size of per-object data keys, selects AES-256.
+const dataKeySize = 32
+
+var errUnknownKey = errors.New("blob is encrypted with an unknown key")
+
+// EncryptionKeyID derives a key ID from the key itself, it is used
+// when the ID of the key is not specified explicitly.
+func EncryptionKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ParseEncryptionKey decodes a hex or base64 encoded AES key.
+func ParseEncryptionKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		if key, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, errors.New("encryption key must be hex or base64 encoded")
+		}
+	}
+
+	if err := checkEncryptionKey(key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func checkEncryptionKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+
+	return fmt.Errorf("invalid encryption key size %d, must be 16, 24 or 32 bytes", len(key))
+}
+
+// encrypt seals data of the object with a random data key, which is in turn sealed with
+// the master key. The object key is used as additional data, so the blob can be opened
+// only under its key. The sealed data key and the original content attributes are stored
+// in object metadata.
+func encrypt(key []byte, keyID, objectKey string, data []byte, opt *PutOptions) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+
+	sealed, err := seal(dataKey, data, []byte(objectKey))
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := seal(key, dataKey, []byte(keyID))
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.Meta == nil {
+		opt.Meta = make(map[string]string)
+	}
+
+	opt.Meta[metaEncAlgorithm] = EncryptionAlgorithm
+	opt.Meta[metaEncKeyID] = keyID
+	opt.Meta[metaEncWrappedKey] = base64.StdEncoding.EncodeToString(wrappedKey)
+	opt.Meta[metaEncContentType] = opt.ContentType
+	opt.Meta[metaEncContentEncoding] = opt.ContentEncoding
+	opt.ContentType = "application/octet-stream"
+	opt.ContentEncoding = ""
+
+	return sealed, nil
+}
+
+// IsEncrypted reports whether the object metadata describes an encrypted blob.
+func IsEncrypted(meta map[string]string) bool {
+	return len(metaGet(meta, metaEncAlgorithm)) > 0
+}
+
+// PlaintextAttributes returns content type and encoding the encrypted blob had
+// before encryption, so the decrypted data could be decoded.
+func PlaintextAttributes(meta map[string]string) (contentType, contentEncoding string) {
+	return metaGet(meta, metaEncContentType), metaGet(meta, metaEncContentEncoding)
+}
+
+// NewDecryptingReader returns a reader of the decrypted blob stored under the object key,
+// the encryption key is found in the keyring by the key ID stored in object metadata.
+func NewDecryptingReader(r io.Reader, objectKey string, meta map[string]string, keys Keyring) (io.Reader, error) {
+	if alg := metaGet(meta, metaEncAlgorithm); alg != EncryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported blob encryption algorithm: %q", alg)
+	}
+
+	keyID := metaGet(meta, metaEncKeyID)
+
+	key, ok := keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownKey, keyID)
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(metaGet(meta, metaEncWrappedKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode wrapped data key: %w", err)
+	}
+
+	dataKey, err := open(key, wrappedKey, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	sealed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := open(dataKey, sealed, []byte(objectKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt blob: %w", err)
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+// seal encrypts data using AES-GCM, the random nonce is prepended to the result.
+func seal(key, data, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, data, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// metaGet looks up metadata value ignoring the key case, since S3 returns
+// metadata keys canonicalized as HTTP headers (e.g. Enc-Key-Id).
+func metaGet(meta map[string]string, key string) string {
+	if v, ok := meta[key]; ok {
+		return v
+	}
+
+	for k, v := range meta {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+
+	return ""
+}
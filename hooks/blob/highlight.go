@@ -0,0 +1,103 @@
+package blob
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Highlight pretty-prints the blob and marks up JSON and HTML syntax with spans
+// of classes key, str, num, lit, tag and com. Other blobs are escaped only.
+func Highlight(blob *Blob) template.HTML {
+	data := PrettyPrint(blob)
+
+	switch {
+	case strings.Contains(blob.ContentType, "json"):
+		return highlightJSON(data)
+	case strings.HasPrefix(blob.ContentType, "text/html"):
+		return highlightHTML(data)
+	default:
+		return template.HTML(template.HTMLEscapeString(string(data))) //nolint:gosec
+	}
+}
+
+func highlightJSON(data []byte) template.HTML {
+	var buf bytes.Buffer
+
+	for i := 0; i < len(data); {
+		c := data[i]
+
+		switch {
+		case c == '"':
+			j := i + 1
+			for ; j < len(data) && data[j] != '"'; j++ {
+				if data[j] == '\\' {
+					j++
+				}
+			}
+
+			if j < len(data) {
+				j++
+			}
+
+			class := "str"
+			if rest := bytes.TrimLeft(data[j:], " \t\r\n"); len(rest) > 0 && rest[0] == ':' {
+				class = "key"
+			}
+
+			writeSpan(&buf, class, data[i:j])
+			i = j
+		case c == '-' || c >= '0' && c <= '9':
+			j := i + 1
+			for ; j < len(data) && strings.IndexByte("0123456789.eE+-", data[j]) >= 0; j++ {
+			}
+
+			writeSpan(&buf, "num", data[i:j])
+			i = j
+		case c == 't' || c == 'f' || c == 'n':
+			j := i + 1
+			for ; j < len(data) && data[j] >= 'a' && data[j] <= 'z'; j++ {
+			}
+
+			writeSpan(&buf, "lit", data[i:j])
+			i = j
+		default:
+			template.HTMLEscape(&buf, data[i:i+1])
+			i++
+		}
+	}
+
+	return template.HTML(buf.String()) //nolint:gosec
+}
+
+func highlightHTML(data []byte) template.HTML {
+	var buf bytes.Buffer
+
+	z := html.NewTokenizer(bytes.NewReader(data))
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		switch raw := z.Raw(); tt {
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			writeSpan(&buf, "tag", raw)
+		case html.CommentToken, html.DoctypeToken:
+			writeSpan(&buf, "com", raw)
+		default:
+			template.HTMLEscape(&buf, raw)
+		}
+	}
+
+	return template.HTML(buf.String()) //nolint:gosec
+}
+
+func writeSpan(buf *bytes.Buffer, class string, text []byte) {
+	buf.WriteString(`<span class="` + class + `">`)
+	template.HTMLEscape(buf, text)
+	buf.WriteString(`</span>`)
+}
@@ -2,9 +2,16 @@ package blob
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -12,6 +19,9 @@ import (
 
 // HookOptions allows to set additional Hook options.
 type HookOptions struct {
+	// BlobStore overrides the storage backend selected by BlobStoreURL.
+	BlobStore BlobStore
+
 	Env               string
 	BlobStoreURL      string
 	BlobStoreAccount  string
@@ -19,12 +29,129 @@ type HookOptions struct {
 	BlobStoreEndpoint string
 	BlobStoreRegion   string
 	BlobStoreBucket   string
-	BlobRetentionTTL  time.Duration
-	BlobEnabledEnv    map[string]bool
+	// LocalDir is the directory blobs are written to in the local env, unless
+	// BlobStore or BlobStoreURL is set. Defaults to output-blobs in the temp dir.
+	LocalDir string
+	// BlobStorePathStyle makes S3 stores address the bucket in the URL path,
+	// as required by some S3 compatible servers.
+	BlobStorePathStyle bool
+	BlobRetentionTTL   time.Duration
+	// BlobExpiresTag tags S3 objects with their expiration time (output-expires),
+	// uploads then require the s3:PutObjectTagging permission.
+	BlobExpiresTag bool
+	BlobEnabledEnv map[string]bool
+	// Rules select entries whose blobs are uploaded, blobs of entries that don't
+	// match any rule are dropped. Blobs of all entries are uploaded if empty.
+	Rules []Rule
+	// BlobFields lists entry fields holding blobs, "blob" by default.
+	BlobFields []string
+	// OffloadThreshold enables offloading of oversized string and []byte values
+	// of any entry field, the values larger than the threshold (in bytes) are uploaded
+	// as blobs and replaced with their URLs, while the size is put into "<field>_size".
+	OffloadThreshold int
+	// KeyMode specifies how object keys of the blobs are generated.
+	KeyMode KeyMode
+	// MultipartThreshold is the blob size starting from which io.Reader blobs are
+	// streamed to the store rather than read into memory, S3 stores use multipart
+	// uploads for such blobs. The hook takes ownership of such readers: they are read
+	// and closed in background after the log call returns, so the caller must not
+	// close or reuse them. Streamed blobs are not spooled, so failed uploads are lost.
+	MultipartThreshold int64
+	// MultipartPartSize is the size of each part of S3 multipart uploads.
+	MultipartPartSize int64
+	// IndexEnabled makes the hook write index records of the blobs into JSON-lines
+	// manifests under <env>/_index, so blobs could be found using SearchIndex.
+	IndexEnabled bool
+	// IndexFields lists entry fields put into index records, e.g. request ID.
+	IndexFields []string
+	// IndexPeriod is the time span of a manifest dir, an hour or a day.
+	IndexPeriod time.Duration
+	// IndexFlushInterval sets how often buffered index records are written.
+	IndexFlushInterval time.Duration
+	// BlobViewerURL is the address of a blob viewer, see NewViewerHandler. When set,
+	// entries get viewer links of the blobs instead of plain blob URLs.
+	BlobViewerURL string
+	// PresignTTL makes the hook put time-limited presigned URLs into entries,
+	// instead of plain blob URLs. Applies to S3 stores only.
+	PresignTTL time.Duration
+	// DedupCacheSize limits the amount of content-addressed blobs remembered
+	// as uploaded, so their existence isn't checked in the store again.
+	DedupCacheSize int
+
+	// BlobLifecycleRule installs a bucket lifecycle rule on startup, which deletes
+	// blobs of the env after BlobRetentionTTL. Applies to S3 stores only.
+	BlobLifecycleRule bool
+	// SweepInterval sets how often expired blobs are deleted from stores
+	// that have no native lifecycle, such as file and memory stores.
+	SweepInterval time.Duration
+
+	// AppVersion specifies version of the app currently running, it is stored in blob metadata.
+	AppVersion string
+	// Compression enables compression of uploaded blobs: "gzip" or "zstd".
+	Compression string
+	// EncryptionKey enables client-side encryption of uploaded blobs, must be
+	// a 16, 24 or 32 bytes long AES key. Each blob is encrypted with its own
+	// data key, which is sealed with EncryptionKey and stored in object metadata.
+	EncryptionKey []byte
+	// EncryptionKeyID identifies EncryptionKey in object metadata, so the right
+	// key could be picked to decrypt the blob. Derived from the key if not set.
+	EncryptionKeyID string
+
+	// UploadConcurrency sets the number of workers uploading blobs in background.
+	UploadConcurrency int
+	// UploadQueueSize limits the amount of blobs waiting to be uploaded.
+	UploadQueueSize int
+	// UploadQueuePolicy specifies what happens when the upload queue is full.
+	UploadQueuePolicy QueuePolicy
+
+	// SpoolDir is a directory that keeps blobs failed to upload until they are retried.
+	SpoolDir string
+	// SpoolDisabled turns off spooling, so failed uploads are lost.
+	SpoolDisabled bool
+	// SpoolMaxBytes limits disk space used by the spool, blobs beyond the limit are lost.
+	SpoolMaxBytes int64
+	// SpoolRetryMin is the delay before the first retry of a spooled blob.
+	SpoolRetryMin time.Duration
+	// SpoolRetryMax limits the exponentially growing delay between retries.
+	SpoolRetryMax time.Duration
 }
 
+var errStoreInaccessible = errors.New("blob store is not accessible")
+
 // DefaultRetentionTTL is currently set to be 3 months.
-const DefaultRenentionTTL = 2232 * time.Hour
+const DefaultRetentionTTL = 2232 * time.Hour
+
+// DefaultRenentionTTL is the misspelled DefaultRetentionTTL.
+//
+// Deprecated: use DefaultRetentionTTL.
+const DefaultRenentionTTL = DefaultRetentionTTL
+
+const (
+	// DefaultUploadConcurrency is the default number of upload workers.
+	DefaultUploadConcurrency = 4
+	// DefaultUploadQueueSize is the default capacity of the upload queue.
+	DefaultUploadQueueSize = 256
+	// DefaultSpoolMaxBytes is the default disk space limit of the spool.
+	DefaultSpoolMaxBytes = 256 << 20
+	// DefaultSpoolRetryMin is the default delay before the first retry.
+	DefaultSpoolRetryMin = 5 * time.Second
+	// DefaultSpoolRetryMax is the default limit of the delay between retries.
+	DefaultSpoolRetryMax = 10 * time.Minute
+)
+
+// HookOptionsFromEnv returns hook options configured by OUTPUT_* env variables,
+// the same way the default outputter configures the hook. It is useful to access
+// the blob store of the hook, e.g. to retrieve logged blobs.
+func HookOptionsFromEnv() *HookOptions {
+	return checkHookOptions(nil)
+}
+
+// FillHookOptions sets the options that are not set by OUTPUT_* env variables and
+// defaults, like NewHook does. Unlike HookOptionsFromEnv, it allows to set options
+// the defaults depend on, e.g. Env, which the default blob store depends on.
+func FillHookOptions(opt *HookOptions) *HookOptions {
+	return checkHookOptions(opt)
+}
 
 func checkHookOptions(opt *HookOptions) *HookOptions {
 	if opt == nil {
@@ -62,8 +189,110 @@ func checkHookOptions(opt *HookOptions) *HookOptions {
 		opt.BlobStoreBucket = os.Getenv("OUTPUT_BLOB_STORE_BUCKET")
 	}
 
+	// in development blobs are written to a local dir, so they could be inspected
+	if opt.Env == "local" && opt.BlobStore == nil && len(opt.BlobStoreURL) == 0 {
+		if len(opt.LocalDir) == 0 {
+			opt.LocalDir = os.Getenv("OUTPUT_BLOB_LOCAL_DIR")
+			if len(opt.LocalDir) == 0 {
+				opt.LocalDir = filepath.Join(os.TempDir(), "output-blobs")
+			}
+		}
+
+		opt.BlobStoreURL = SchemeFile + "://" + filepath.ToSlash(opt.LocalDir)
+	}
+
+	if !opt.BlobStorePathStyle {
+		opt.BlobStorePathStyle = isTrue(os.Getenv("OUTPUT_BLOB_STORE_PATH_STYLE"))
+	}
+
 	if opt.BlobRetentionTTL == 0 {
-		opt.BlobRetentionTTL = DefaultRenentionTTL
+		opt.BlobRetentionTTL, _ = time.ParseDuration(os.Getenv("OUTPUT_BLOB_RETENTION_TTL"))
+		if opt.BlobRetentionTTL == 0 {
+			opt.BlobRetentionTTL = DefaultRetentionTTL
+		}
+	}
+
+	if len(opt.BlobFields) == 0 {
+		for _, field := range strings.Split(os.Getenv("OUTPUT_BLOB_FIELDS"), ",") {
+			if field = strings.TrimSpace(field); len(field) > 0 {
+				opt.BlobFields = append(opt.BlobFields, field)
+			}
+		}
+
+		if len(opt.BlobFields) == 0 {
+			opt.BlobFields = []string{"blob"}
+		}
+	}
+
+	if opt.OffloadThreshold <= 0 {
+		opt.OffloadThreshold, _ = strconv.Atoi(os.Getenv("OUTPUT_BLOB_OFFLOAD_THRESHOLD"))
+	}
+
+	if opt.KeyMode == KeyModeULID {
+		if keyMode, ok := ParseKeyMode(os.Getenv("OUTPUT_BLOB_KEY_MODE")); ok {
+			opt.KeyMode = keyMode
+		}
+	}
+
+	if opt.MultipartThreshold <= 0 {
+		opt.MultipartThreshold, _ = strconv.ParseInt(os.Getenv("OUTPUT_BLOB_MULTIPART_THRESHOLD"), 10, 64)
+		if opt.MultipartThreshold <= 0 {
+			opt.MultipartThreshold = DefaultMultipartThreshold
+		}
+	}
+
+	if opt.MultipartPartSize <= 0 {
+		opt.MultipartPartSize, _ = strconv.ParseInt(os.Getenv("OUTPUT_BLOB_MULTIPART_PART_SIZE"), 10, 64)
+	}
+
+	if !opt.IndexEnabled {
+		opt.IndexEnabled = isTrue(os.Getenv("OUTPUT_BLOB_INDEX"))
+	}
+
+	if len(opt.IndexFields) == 0 {
+		for _, field := range strings.Split(os.Getenv("OUTPUT_BLOB_INDEX_FIELDS"), ",") {
+			if field = strings.TrimSpace(field); len(field) > 0 {
+				opt.IndexFields = append(opt.IndexFields, field)
+			}
+		}
+	}
+
+	if opt.IndexPeriod <= 0 {
+		opt.IndexPeriod, _ = time.ParseDuration(os.Getenv("OUTPUT_BLOB_INDEX_PERIOD"))
+		if opt.IndexPeriod <= 0 {
+			opt.IndexPeriod = DefaultIndexPeriod
+		}
+	}
+
+	if opt.IndexFlushInterval <= 0 {
+		opt.IndexFlushInterval = DefaultIndexFlushInterval
+	}
+
+	if len(opt.BlobViewerURL) == 0 {
+		opt.BlobViewerURL = os.Getenv("OUTPUT_BLOB_VIEWER_URL")
+	}
+
+	if opt.PresignTTL <= 0 {
+		opt.PresignTTL, _ = time.ParseDuration(os.Getenv("OUTPUT_BLOB_PRESIGN_TTL"))
+	}
+
+	if opt.DedupCacheSize <= 0 {
+		opt.DedupCacheSize, _ = strconv.Atoi(os.Getenv("OUTPUT_BLOB_DEDUP_CACHE_SIZE"))
+		if opt.DedupCacheSize <= 0 {
+			opt.DedupCacheSize = DefaultDedupCacheSize
+		}
+	}
+
+	if !opt.BlobExpiresTag {
+		opt.BlobExpiresTag = isTrue(os.Getenv("OUTPUT_BLOB_EXPIRES_TAG"))
+	}
+
+	if !opt.BlobLifecycleRule {
+		opt.BlobLifecycleRule = isTrue(os.Getenv("OUTPUT_BLOB_LIFECYCLE_RULE"))
+	}
+
+	if opt.SweepInterval <= 0 {
+		opt.SweepInterval = DefaultSweepInterval
 	}
 
 	if len(opt.BlobEnabledEnv) == 0 {
@@ -72,50 +301,232 @@ func checkHookOptions(opt *HookOptions) *HookOptions {
 			"staging": true,
 			"test":    true,
 		}
+
+		// local stores are fine to be used on dev laptops and CI
+		switch blobStoreScheme(opt.BlobStoreURL) {
+		case SchemeFile, SchemeMemory:
+			opt.BlobEnabledEnv["local"] = true
+		}
+	}
+
+	if len(opt.AppVersion) == 0 {
+		opt.AppVersion = os.Getenv("OUTPUT_APP_VERSION")
+	}
+
+	if len(opt.Compression) == 0 {
+		opt.Compression = strings.ToLower(os.Getenv("OUTPUT_BLOB_COMPRESSION"))
+	}
+
+	if opt.UploadConcurrency <= 0 {
+		opt.UploadConcurrency, _ = strconv.Atoi(os.Getenv("OUTPUT_BLOB_UPLOAD_CONCURRENCY"))
+		if opt.UploadConcurrency <= 0 {
+			opt.UploadConcurrency = DefaultUploadConcurrency
+		}
+	}
+
+	if opt.UploadQueueSize <= 0 {
+		opt.UploadQueueSize, _ = strconv.Atoi(os.Getenv("OUTPUT_BLOB_UPLOAD_QUEUE_SIZE"))
+		if opt.UploadQueueSize <= 0 {
+			opt.UploadQueueSize = DefaultUploadQueueSize
+		}
+	}
+
+	if opt.UploadQueuePolicy == QueuePolicyBlock {
+		if policy, ok := ParseQueuePolicy(os.Getenv("OUTPUT_BLOB_UPLOAD_QUEUE_POLICY")); ok {
+			opt.UploadQueuePolicy = policy
+		}
+	}
+
+	if !opt.SpoolDisabled {
+		opt.SpoolDisabled = isTrue(os.Getenv("OUTPUT_BLOB_SPOOL_DISABLED"))
+	}
+
+	if len(opt.SpoolDir) == 0 {
+		opt.SpoolDir = os.Getenv("OUTPUT_BLOB_SPOOL_DIR")
+		if len(opt.SpoolDir) == 0 {
+			opt.SpoolDir = filepath.Join(os.TempDir(), "output-blob-spool", opt.Env)
+		}
+	}
+
+	if opt.SpoolMaxBytes <= 0 {
+		opt.SpoolMaxBytes, _ = strconv.ParseInt(os.Getenv("OUTPUT_BLOB_SPOOL_MAX_BYTES"), 10, 64)
+		if opt.SpoolMaxBytes <= 0 {
+			opt.SpoolMaxBytes = DefaultSpoolMaxBytes
+		}
+	}
+
+	if opt.SpoolRetryMin <= 0 {
+		opt.SpoolRetryMin = DefaultSpoolRetryMin
+	}
+
+	if opt.SpoolRetryMax < opt.SpoolRetryMin {
+		opt.SpoolRetryMax = DefaultSpoolRetryMax
+		if opt.SpoolRetryMax < opt.SpoolRetryMin {
+			opt.SpoolRetryMax = opt.SpoolRetryMin
+		}
 	}
 
 	return opt
 }
 
+func isTrue(v string) bool {
+	switch strings.ToLower(v) {
+	case "1", "true", "y":
+		return true
+	}
+
+	return false
+}
+
+// Stats contains metrics of the blob hook.
+type Stats struct {
+	// Dropped is the total amount of blobs discarded due to a full upload queue.
+	Dropped uint64
+	// Spool contains metrics of the spool of failed uploads.
+	Spool SpoolStats
+}
+
+// Hook is a logrus.Hook that uploads blobs in background. Pending uploads
+// can be awaited using Flush, Close also stops the upload workers.
+type Hook interface {
+	logrus.Hook
+
+	Flush(ctx context.Context) error
+	Close() error
+	Stats() Stats
+	Status() Status
+}
+
 // NewHook initializes a new output.Hook using provided params and options.
-func NewHook(opt *HookOptions) (blobHook logrus.Hook, err error) {
+func NewHook(opt *HookOptions) (blobHook Hook, err error) {
 	var h hook
 	h.opt = checkHookOptions(opt)
 
-	if err = h.initS3Remote(); err != nil {
+	if !isValidCompression(h.opt.Compression) {
+		err = fmt.Errorf("unsupported blob compression: %s", h.opt.Compression)
 		return
 	}
 
+	if err = h.initEncryption(); err != nil {
+		return
+	}
+
+	if len(h.opt.Rules) == 0 {
+		if h.opt.Rules, err = ParseRules(os.Getenv("OUTPUT_BLOB_RULES")); err != nil {
+			err = fmt.Errorf("failed to parse OUTPUT_BLOB_RULES: %+v", err)
+			return
+		}
+	}
+
+	if h.store = h.opt.BlobStore; h.store == nil {
+		if h.store, err = NewBlobStore(h.opt); err != nil {
+			err = fmt.Errorf("failed to init blob store: %+v", err)
+			return
+		}
+	}
+
+	if !h.opt.SpoolDisabled {
+		if h.spool, err = newSpool(
+			h.opt.SpoolDir,
+			h.opt.SpoolMaxBytes,
+			h.opt.SpoolRetryMin,
+			h.opt.SpoolRetryMax,
+			h.putObject,
+		); err != nil {
+			logrus.WithError(err).Warningln("blob spool is disabled, failed uploads will be lost")
+			err = nil
+		}
+	}
+
+	h.dedupCache = newLRUCache(h.opt.DedupCacheSize)
+	h.uploader = newUploader(
+		h.opt.UploadConcurrency,
+		h.opt.UploadQueueSize,
+		h.opt.UploadQueuePolicy,
+		h.blobUpload,
+	)
+
+	if h.opt.IndexEnabled {
+		h.indexer = newIndexer(h.opt.Env, h.opt.IndexPeriod, h.opt.IndexFlushInterval, h.enqueueIndexPart)
+	}
+
+	h.initDone = make(chan struct{})
+	h.checkDone = make(chan struct{})
+	h.stop = make(chan struct{})
+
+	go h.checkAccessLoop()
+
 	blobHook = &h
 
 	return
 }
 
 type hook struct {
-	opt      *HookOptions
-	s3Remote S3Remote
-}
-
-func (h *hook) initS3Remote() (err error) {
-	if h.s3Remote, err = NewS3Remote(
-		h.opt.BlobStoreAccount,
-		h.opt.BlobStoreKey,
-		h.opt.BlobStoreEndpoint,
-		h.opt.BlobStoreRegion,
-		h.opt.BlobStoreBucket,
-	); err != nil {
-		err = fmt.Errorf("failed to init S3 session: %+v", err)
+	opt        *HookOptions
+	store      BlobStore
+	uploader   *uploader
+	spool      *spool
+	sweeper    *Sweeper
+	indexer    *indexer
+	dedupCache *lruCache
+
+	statusMux sync.RWMutex
+	status    Status
+
+	// initDone is closed after the first access check of the store.
+	initDone  chan struct{}
+	initOnce  sync.Once
+	checkDone chan struct{}
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+func (h *hook) initEncryption() (err error) {
+	if len(h.opt.EncryptionKey) == 0 {
+		if envKey := os.Getenv("OUTPUT_BLOB_ENCRYPTION_KEY"); len(envKey) > 0 {
+			if h.opt.EncryptionKey, err = ParseEncryptionKey(envKey); err != nil {
+				err = fmt.Errorf("failed to parse OUTPUT_BLOB_ENCRYPTION_KEY: %+v", err)
+				return
+			}
+		}
+	}
+
+	if len(h.opt.EncryptionKey) == 0 {
 		return
 	}
 
-	if err = h.s3Remote.CheckAccess(h.opt.Env); err != nil {
-		err = fmt.Errorf("failed to verify S3 remote access: %+v", err)
+	if err = checkEncryptionKey(h.opt.EncryptionKey); err != nil {
 		return
 	}
 
+	if len(h.opt.EncryptionKeyID) == 0 {
+		h.opt.EncryptionKeyID = os.Getenv("OUTPUT_BLOB_ENCRYPTION_KEY_ID")
+		if len(h.opt.EncryptionKeyID) == 0 {
+			h.opt.EncryptionKeyID = EncryptionKeyID(h.opt.EncryptionKey)
+		}
+	}
+
 	return
 }
 
+// initRetention makes the store expire blobs after the retention TTL, either using
+// a native lifecycle of the store or by sweeping expired blobs in background.
+func (h *hook) initRetention() {
+	if h.opt.BlobRetentionTTL <= 0 {
+		return
+	}
+
+	if installer, ok := h.store.(LifecycleInstaller); ok && h.opt.BlobLifecycleRule {
+		if err := installer.InstallLifecycleRule(h.opt.Env, h.opt.BlobRetentionTTL); err != nil {
+			logrus.WithError(err).Warningln("failed to install blob store lifecycle rule")
+		}
+	}
+
+	if deleter, ok := h.store.(ExpiredObjectsDeleter); ok {
+		h.sweeper = NewSweeper(deleter, h.opt.SweepInterval)
+	}
+}
+
 func (h *hook) Levels() []logrus.Level {
 	return []logrus.Level{
 		logrus.PanicLevel,
@@ -129,55 +540,415 @@ func (h *hook) Levels() []logrus.Level {
 }
 
 func (h *hook) Fire(e *logrus.Entry) error {
-	blob, hasBlob := e.Data["blob"]
-	if !hasBlob {
+	var blobFields []string
+
+	for _, field := range h.opt.BlobFields {
+		if _, ok := e.Data[field]; ok {
+			blobFields = append(blobFields, field)
+		}
+	}
+
+	offloadFields := h.oversizedFields(e.Data)
+
+	if len(blobFields) == 0 && len(offloadFields) == 0 {
 		return nil
 	}
 
-	if h.s3Remote == nil {
-		logrus.Warning("blob provided but S3 remote is disabled")
-		delete(e.Data, "blob")
+	// entry data is shared with the outputter that created the entry, copy it
+	// before replacing blobs with URLs, so the next entries carry the original blobs.
+	e.Data = copyFields(e.Data)
+
+	// oversized fields are kept as is if blobs can't be uploaded
+	if h.isDiscarding() {
+		if len(blobFields) > 0 {
+			logrus.Warning("blob provided but blob store is not accessible")
+		}
+
+		deleteFields(e.Data, blobFields)
 
 		return nil
 	} else if enabled := h.opt.BlobEnabledEnv[h.opt.Env]; !enabled {
-		logrus.Infof("blob provided but uploading is disabled in %s", h.opt.Env)
-		delete(e.Data, "blob")
+		if len(blobFields) > 0 {
+			logrus.Infof("blob provided but uploading is disabled in %s", h.opt.Env)
+		}
+
+		deleteFields(e.Data, blobFields)
+
+		return nil
+	} else if !h.matchRules(e) {
+		deleteFields(e.Data, blobFields)
 		return nil
 	}
 
-	var blobPayload []byte
-	switch bb := blob.(type) {
-	case string:
-		blobPayload = []byte(bb)
-	case []byte:
-		blobPayload = make([]byte, len(bb))
-		copy(blobPayload, bb)
-	default:
-		delete(e.Data, "blob")
+	for _, field := range blobFields {
+		h.fireBlob(e, field)
+	}
+
+	for _, field := range offloadFields {
+		size := fieldSize(e.Data[field])
+		if h.fireBlob(e, field) {
+			e.Data[field+"_size"] = size
+		}
+	}
+
+	return nil
+}
+
+// oversizedFields returns names of non-blob fields having values
+// larger than the offload threshold.
+func (h *hook) oversizedFields(data logrus.Fields) []string {
+	if h.opt.OffloadThreshold <= 0 {
 		return nil
 	}
 
-	blobID := NewBlobID()
+	var fields []string
+
+	for field, v := range data {
+		if fieldSize(v) > h.opt.OffloadThreshold && !h.isBlobField(field) {
+			fields = append(fields, field)
+		}
+	}
+
+	sort.Strings(fields)
 
-	if len(h.opt.BlobStoreURL) > 0 {
-		e.Data["blob"] = fmt.Sprintf("%s/%s", h.opt.BlobStoreURL, blobID)
+	return fields
+}
+
+func (h *hook) isBlobField(field string) bool {
+	for _, blobField := range h.opt.BlobFields {
+		if field == blobField {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fireBlob enqueues upload of the blob field value and replaces it with the blob URL.
+// It returns false if the blob has been dropped and the field is deleted.
+func (h *hook) fireBlob(e *logrus.Entry, field string) bool {
+	var (
+		payload     []byte
+		body        io.Reader
+		contentType string
+		err         error
+	)
+
+	if r, ok := e.Data[field].(io.Reader); ok && h.canStream() {
+		payload, body, err = readBlobHead(r, h.opt.MultipartThreshold)
 	} else {
-		e.Data["blob"] = fmt.Sprintf("%s/%s", h.opt.Env, blobID)
+		payload, contentType, err = blobPayload(e.Data[field])
+	}
+
+	if err != nil {
+		logrus.WithError(err).WithField("field", field).Warningln("failed to read blob")
+		delete(e.Data, field)
+
+		return false
+	} else if len(payload) == 0 {
+		delete(e.Data, field)
+		return false
+	}
+
+	blobID := NewBlobID()
+	if h.opt.KeyMode == KeyModeContentHash {
+		blobID = contentHash(payload, h.opt.EncryptionKey)
+	}
+
+	job := &uploadJob{
+		key:     filepath.Join(h.opt.Env, blobID),
+		dedup:   h.opt.KeyMode == KeyModeContentHash,
+		payload: payload,
+		body:    body,
+		opt: &PutOptions{
+			ContentType: contentType,
+			Meta:        h.blobMeta(e, field),
+		},
+	}
+
+	if h.opt.BlobRetentionTTL > 0 {
+		job.opt.Expires = e.Time.Add(h.opt.BlobRetentionTTL)
+	}
+
+	if err := h.uploader.Enqueue(job); err != nil {
+		logrus.WithError(err).WithField("dropped", h.uploader.Dropped()).Warningln("blob dropped")
+		delete(e.Data, field)
+
+		return false
+	}
+
+	e.Data[field] = h.blobURL(job.key, blobID)
+
+	if h.indexer != nil {
+		h.indexer.Add(h.indexRecord(e, field, job.key))
+	}
+
+	return true
+}
+
+// indexRecord returns the index record of the blob logged in the entry field.
+func (h *hook) indexRecord(e *logrus.Entry, field, key string) *IndexRecord {
+	rec := &IndexRecord{
+		Key:     key,
+		Field:   field,
+		Time:    e.Time,
+		Level:   e.Level.String(),
+		Message: e.Message,
+	}
+
+	for _, indexField := range h.opt.IndexFields {
+		if v, ok := e.Data[indexField]; ok {
+			if rec.Fields == nil {
+				rec.Fields = make(map[string]string)
+			}
+
+			rec.Fields[indexField] = fmt.Sprint(v)
+		}
+	}
+
+	return rec
+}
+
+// enqueueIndexPart uploads the manifest part the same way as blobs,
+// so it's compressed, encrypted and spooled on failures too.
+func (h *hook) enqueueIndexPart(key string, data []byte) error {
+	job := &uploadJob{
+		key:     key,
+		payload: data,
+		opt: &PutOptions{
+			ContentType: indexContentType,
+		},
+	}
+
+	if h.opt.BlobRetentionTTL > 0 {
+		job.opt.Expires = time.Now().Add(h.opt.BlobRetentionTTL)
 	}
 
-	h.blobUpload(blobID, blobPayload)
+	return h.uploader.Enqueue(job)
+}
+
+// blobMeta returns metadata describing the log entry the blob belongs to. Object metadata
+// is not encrypted, so the entry is not described if encryption is enabled.
+func (h *hook) blobMeta(e *logrus.Entry, field string) map[string]string {
+	meta := make(map[string]string)
+
+	if len(h.opt.EncryptionKey) == 0 {
+		meta["level"] = e.Level.String()
+		meta["message"] = metaValue(e.Message, 256)
+		meta["env"] = metaValue(h.opt.Env, 64)
+		meta["field"] = metaValue(field, 64)
+	}
+
+	if len(h.opt.AppVersion) > 0 {
+		meta["app-version"] = metaValue(h.opt.AppVersion, 64)
+	}
+
+	return meta
+}
+
+// blobURL returns a reference to the blob that is put into log entries.
+func (h *hook) blobURL(key, blobID string) string {
+	if len(h.opt.BlobViewerURL) > 0 {
+		return fmt.Sprintf("%s/%s", strings.TrimSuffix(h.opt.BlobViewerURL, "/"), key)
+	}
+
+	if presigner, ok := h.store.(Presigner); ok && h.opt.PresignTTL > 0 {
+		presignedURL, err := presigner.PresignGetObject(key, h.opt.PresignTTL)
+		if err == nil {
+			return presignedURL
+		}
+
+		logrus.WithError(err).WithField("key", key).Warningln("failed to presign blob URL")
+	}
+
+	if urler, ok := h.store.(ObjectURLer); ok {
+		return urler.ObjectURL(key)
+	}
+
+	switch blobStoreScheme(h.opt.BlobStoreURL) {
+	case "":
+		return key
+	case "http", "https":
+		return fmt.Sprintf("%s/%s", h.opt.BlobStoreURL, blobID)
+	default:
+		return fmt.Sprintf("%s/%s", strings.TrimSuffix(h.opt.BlobStoreURL, "/"), key)
+	}
+}
+
+// Flush waits until all blobs queued so far are uploaded or the context is done.
+func (h *hook) Flush(ctx context.Context) error {
+	if h.indexer != nil {
+		h.indexer.Flush()
+	}
+
+	return h.uploader.Flush(ctx)
+}
+
+// Close uploads the remaining blobs and stops the upload workers.
+// Blobs provided after Close are discarded, spooled blobs are kept
+// on disk and will be retried by the next hook using the same spool dir.
+func (h *hook) Close() error {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+	})
+
+	<-h.checkDone
+
+	if h.indexer != nil {
+		h.indexer.Close()
+	}
+
+	err := h.uploader.Close()
+
+	if h.spool != nil {
+		if spoolErr := h.spool.Close(); err == nil {
+			err = spoolErr
+		}
+	}
+
+	if h.sweeper != nil {
+		h.sweeper.Close()
+	}
+
+	return err
+}
+
+// Stats returns metrics of the hook.
+func (h *hook) Stats() Stats {
+	stats := Stats{
+		Dropped: h.uploader.Dropped(),
+	}
+
+	if h.spool != nil {
+		stats.Spool = h.spool.Stats()
+	}
+
+	return stats
+}
+
+func (h *hook) blobUpload(job *uploadJob) {
+	// wait for the first access check, so the store state is known
+	<-h.initDone
+
+	degraded := h.Status().State == StateDegraded
+
+	if job.body != nil {
+		h.streamUpload(job, degraded)
+		return
+	} else if degraded && h.spool == nil {
+		return
+	}
+
+	if !degraded && job.dedup && h.isUploaded(job) {
+		return
+	}
+
+	if err := h.encodeBlob(job); err != nil {
+		logrus.WithError(err).WithField("key", job.key).Errorln("failed to encode blob")
+		return
+	}
+
+	// skip the upload attempt if the store is known to be inaccessible
+	err := errStoreInaccessible
+	if !degraded {
+		err = h.putObject(job)
+	}
+
+	if err == nil {
+		if job.dedup {
+			h.dedupCache.Add(job.key, job.opt.Expires)
+		}
+
+		return
+	}
+
+	if h.spool != nil {
+		spoolErr := h.spool.Put(job)
+		if spoolErr == nil && degraded {
+			logrus.WithField("key", job.key).Debugln("blob store is not accessible, blob spooled")
+			return
+		} else if spoolErr == nil {
+			logrus.WithError(err).WithField("key", job.key).Warningln("failed to upload blob, spooled for retry")
+			return
+		}
+
+		err = fmt.Errorf("%v (spool: %v)", err, spoolErr)
+	}
+
+	logrus.WithError(err).WithFields(logrus.Fields{
+		"bucket": h.opt.BlobStoreBucket,
+		"key":    job.key,
+	}).Errorln("failed to upload blob to remote store")
+}
+
+// encodeBlob detects content type of the blob payload, then compresses
+// and encrypts it if enabled.
+func (h *hook) encodeBlob(job *uploadJob) (err error) {
+	if len(job.opt.ContentType) == 0 {
+		job.opt.ContentType = DetectContentType(job.payload)
+	}
+
+	if len(h.opt.Compression) > 0 && !isCompressedContentType(job.opt.ContentType) {
+		if job.payload, err = compress(h.opt.Compression, job.payload); err != nil {
+			return err
+		}
+
+		job.opt.ContentEncoding = h.opt.Compression
+	}
+
+	if len(h.opt.EncryptionKey) > 0 {
+		if job.payload, err = encrypt(h.opt.EncryptionKey, h.opt.EncryptionKeyID, job.key, job.payload, job.opt); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (h *hook) blobUpload(blobID string, payload []byte) {
-	objectKey := filepath.Join(h.opt.Env, blobID)
-	_, err := h.s3Remote.PutObject(objectKey, bytes.NewReader(payload), nil)
+// canStream reports whether io.Reader blobs could be streamed to the store,
+// it's not possible if the whole payload is needed to encrypt or hash it.
+func (h *hook) canStream() bool {
+	return len(h.opt.EncryptionKey) == 0 && h.opt.KeyMode == KeyModeULID
+}
 
-	if err != nil {
+// streamUpload uploads a large io.Reader blob without reading it into memory.
+// Such blobs can't be spooled, so they are lost if the upload fails.
+func (h *hook) streamUpload(job *uploadJob, degraded bool) {
+	defer closeReader(job.body)
+
+	if degraded {
+		logrus.WithField("key", job.key).Warningln("blob store is not accessible, streamed blob dropped without spooling")
+		return
+	}
+
+	if len(job.opt.ContentType) == 0 {
+		job.opt.ContentType = DetectContentType(job.payload)
+	}
+
+	r := io.MultiReader(bytes.NewReader(job.payload), job.body)
+
+	if len(h.opt.Compression) > 0 && !isCompressedContentType(job.opt.ContentType) {
+		pr, pw := io.Pipe()
+		defer pr.Close()
+
+		go func(r io.Reader) {
+			pw.CloseWithError(compressTo(h.opt.Compression, pw, r))
+		}(r)
+
+		r = pr
+		job.opt.ContentEncoding = h.opt.Compression
+	}
+
+	if _, err := h.store.PutObject(job.key, r, job.opt); err != nil {
 		logrus.WithError(err).WithFields(logrus.Fields{
 			"bucket": h.opt.BlobStoreBucket,
-			"key":    objectKey,
-		}).Errorln("failed to upload blob to S3 remote server")
+			"key":    job.key,
+		}).Errorln("failed to upload streamed blob to remote store, streamed blobs are not spooled")
 	}
 }
+
+func (h *hook) putObject(job *uploadJob) error {
+	_, err := h.store.PutObject(job.key, bytes.NewReader(job.payload), job.opt)
+
+	return err
+}
@@ -0,0 +1,304 @@
+package blob
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// IndexRecord describes a logged blob, index records are stored in JSON-lines
+// manifest parts, so blobs could be found by the log entries they belong to.
+type IndexRecord struct {
+	Key     string            `json:"key"`
+	Field   string            `json:"field"`
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"msg"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// IndexQuery specifies index records to search for, zero conditions match any record.
+type IndexQuery struct {
+	// From and To limit the time range of the records.
+	From, To time.Time
+	// Level is the exact level of the records.
+	Level string
+	// Message is a substring of the record messages.
+	Message string
+	// Fields are values of the record fields.
+	Fields map[string]string
+	// Limit is the maximum amount of records returned, the earliest records are returned.
+	Limit int
+}
+
+const (
+	// DefaultIndexPeriod is the default time span of the records in a manifest dir.
+	DefaultIndexPeriod = time.Hour
+	// DefaultIndexFlushInterval is the default interval of writing manifest parts.
+	DefaultIndexFlushInterval = time.Minute
+
+	indexDir         = "_index"
+	indexPartSuffix  = ".jsonl"
+	indexContentType = "application/x-ndjson"
+
+	// indexMaxRecords is the amount of buffered records that are written
+	// without waiting for the flush interval.
+	indexMaxRecords = 1000
+)
+
+// indexPrefix returns the prefix of manifest parts of the env.
+func indexPrefix(env string) string {
+	return path.Join(env, indexDir) + "/"
+}
+
+// indexPartDir returns the manifest dir of the record time, e.g. prod/_index/2020/06/01/15
+// for hourly manifests and prod/_index/2020/06/01 for daily ones.
+func indexPartDir(env string, ts time.Time, period time.Duration) string {
+	layout := "2006/01/02/15"
+	if period >= 24*time.Hour {
+		layout = "2006/01/02"
+	}
+
+	return indexPrefix(env) + ts.UTC().Format(layout)
+}
+
+// indexPartTime returns the start of the manifest dir period of the part key.
+func indexPartTime(prefix, key string) (time.Time, bool) {
+	dir := path.Dir(strings.TrimPrefix(key, prefix))
+
+	for _, layout := range []string{"2006/01/02/15", "2006/01/02"} {
+		if ts, err := time.Parse(layout, dir); err == nil {
+			return ts, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// indexer buffers index records and writes them into manifest parts,
+// a new part is written per dir on every flush, as objects can't be appended.
+type indexer struct {
+	env     string
+	period  time.Duration
+	enqueue func(key string, data []byte) error
+
+	mux     sync.Mutex
+	records []*IndexRecord
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newIndexer(env string, period, flushInterval time.Duration, enqueue func(key string, data []byte) error) *indexer {
+	x := &indexer{
+		env:     env,
+		period:  period,
+		enqueue: enqueue,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go x.flushLoop(flushInterval)
+
+	return x
+}
+
+// Add buffers the record, records are written when the buffer is full
+// or on the next flush.
+func (x *indexer) Add(rec *IndexRecord) {
+	x.mux.Lock()
+	x.records = append(x.records, rec)
+	full := len(x.records) >= indexMaxRecords
+	x.mux.Unlock()
+
+	if full {
+		x.Flush()
+	}
+}
+
+// Flush writes the buffered records into manifest parts.
+func (x *indexer) Flush() {
+	x.mux.Lock()
+	records := x.records
+	x.records = nil
+	x.mux.Unlock()
+
+	if len(records) == 0 {
+		return
+	}
+
+	parts := make(map[string]*bytes.Buffer)
+
+	for _, rec := range records {
+		dir := indexPartDir(x.env, rec.Time, x.period)
+
+		buf, ok := parts[dir]
+		if !ok {
+			buf = new(bytes.Buffer)
+			parts[dir] = buf
+		}
+
+		data, err := json.Marshal(rec)
+		if err != nil {
+			continue
+		}
+
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	for dir, buf := range parts {
+		key := path.Join(dir, NewBlobID()+indexPartSuffix)
+		if err := x.enqueue(key, buf.Bytes()); err != nil {
+			logrus.WithError(err).WithField("key", key).Warningln("blob index records dropped")
+		}
+	}
+}
+
+// Close writes the remaining records and stops the flush loop.
+func (x *indexer) Close() {
+	x.stopOnce.Do(func() {
+		close(x.stop)
+	})
+
+	<-x.done
+
+	x.Flush()
+}
+
+func (x *indexer) flushLoop(interval time.Duration) {
+	defer close(x.done)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-x.stop:
+			return
+		case <-t.C:
+			x.Flush()
+		}
+	}
+}
+
+// SearchIndex returns index records of the env matching the query, sorted by time.
+// Keys are used to open manifest parts of hooks with encryption enabled.
+func SearchIndex(store BlobStore, env string, q *IndexQuery, keys Keyring) ([]*IndexRecord, error) {
+	if q == nil {
+		q = &IndexQuery{}
+	}
+
+	prefix := indexPrefix(env)
+	opt := &ListOptions{
+		Prefix: prefix,
+		Limit:  listPageSize,
+	}
+
+	if !q.From.IsZero() {
+		// the dir of the day, hourly dirs of the day follow it
+		opt.StartAfter = prefix + q.From.UTC().Format("2006/01/02")
+	}
+
+	var records []*IndexRecord
+
+	for {
+		specs, err := store.ListObjects(opt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, spec := range specs {
+			ts, ok := indexPartTime(prefix, spec.Key)
+			if !ok || !strings.HasSuffix(spec.Key, indexPartSuffix) {
+				continue
+			} else if !q.To.IsZero() && ts.After(q.To) {
+				return limitRecords(records, q.Limit), nil
+			}
+
+			partRecords, err := searchIndexPart(store, spec.Key, q, keys)
+			if err != nil {
+				return nil, err
+			}
+
+			records = append(records, partRecords...)
+		}
+
+		if len(specs) < opt.Limit {
+			return limitRecords(records, q.Limit), nil
+		}
+
+		opt.StartAfter = specs[len(specs)-1].Key
+	}
+}
+
+func searchIndexPart(store BlobStore, key string, q *IndexQuery, keys Keyring) ([]*IndexRecord, error) {
+	spec, err := store.GetObject(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob index part %s: %w", key, err)
+	}
+
+	part, err := DecodeBlob(spec, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode blob index part %s: %w", key, err)
+	}
+
+	var records []*IndexRecord
+
+	scanner := bufio.NewScanner(bytes.NewReader(part.Data))
+	scanner.Buffer(nil, len(part.Data)+1)
+
+	for scanner.Scan() {
+		var rec IndexRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+
+		if q.match(&rec) {
+			records = append(records, &rec)
+		}
+	}
+
+	return records, scanner.Err()
+}
+
+func (q *IndexQuery) match(rec *IndexRecord) bool {
+	if !q.From.IsZero() && rec.Time.Before(q.From) {
+		return false
+	} else if !q.To.IsZero() && rec.Time.After(q.To) {
+		return false
+	} else if len(q.Level) > 0 && rec.Level != q.Level {
+		return false
+	} else if !strings.Contains(rec.Message, q.Message) {
+		return false
+	}
+
+	for field, value := range q.Fields {
+		if rec.Fields[field] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func limitRecords(records []*IndexRecord, limit int) []*IndexRecord {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+
+	return records
+}
@@ -0,0 +1,68 @@
+package blob
+
+import (
+	"path"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// listPageSize is the amount of objects requested from the store at once.
+const listPageSize = 1000
+
+// ListBlobs returns blobs of the env that have been logged within the time range,
+// using the fact that ULID keys are sorted by time. Zero from or to leaves the range
+// open. Objects with keys that are not ULIDs, e.g. content-addressed blobs, are skipped.
+func ListBlobs(store BlobStore, env string, from, to time.Time) ([]*ObjectSpec, error) {
+	prefix := env + "/"
+	opt := &ListOptions{
+		Prefix: prefix,
+		Limit:  listPageSize,
+	}
+
+	if !from.IsZero() {
+		// the smallest ULID of the millisecond, keys of the blobs logged within it follow it
+		var id ulid.ULID
+		if err := id.SetTime(ulid.Timestamp(from)); err != nil {
+			return nil, err
+		}
+
+		opt.StartAfter = prefix + id.String()
+	}
+
+	var blobs []*ObjectSpec
+
+	for {
+		specs, err := store.ListObjects(opt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, spec := range specs {
+			ts, ok := BlobTime(spec.Key)
+			if !ok {
+				continue
+			} else if !to.IsZero() && ts.After(to) {
+				return blobs, nil
+			}
+
+			blobs = append(blobs, spec)
+		}
+
+		if len(specs) < opt.Limit {
+			return blobs, nil
+		}
+
+		opt.StartAfter = specs[len(specs)-1].Key
+	}
+}
+
+// BlobTime returns the time encoded in the ULID of the blob key or URL.
+func BlobTime(key string) (time.Time, bool) {
+	id, err := ulid.ParseStrict(path.Base(key))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return ulid.Time(id.Time()), true
+}
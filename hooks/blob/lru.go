@@ -0,0 +1,63 @@
+package blob
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache is a fixed size cache that evicts the least recently used keys.
+type lruCache struct {
+	size int
+
+	mux   sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	value interface{}
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		return el.Value.(*lruItem).value, true
+	}
+
+	return nil, false
+}
+
+func (c *lruCache) Add(key string, value interface{}) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*lruItem).value = value
+
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruItem{
+		key:   key,
+		value: value,
+	})
+
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem).key)
+	}
+}
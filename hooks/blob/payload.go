@@ -0,0 +1,116 @@
+package blob
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/sirupsen/logrus"
+)
+
+// blobPayload converts a blob field value into the payload to upload. Besides
+// raw strings and bytes, readers are read until EOF and closed, JSON marshalers and any
+// other values are marshalled to JSON, fmt.Stringer values are uploaded as text.
+// The payload is empty if there is nothing to upload.
+func blobPayload(v interface{}) (payload []byte, contentType string, err error) {
+	switch vv := v.(type) {
+	case nil:
+		return nil, "", nil
+	case string:
+		return []byte(vv), "", nil
+	case []byte:
+		// copy the bytes, since the caller is free to reuse them after logging
+		payload = make([]byte, len(vv))
+		copy(payload, vv)
+
+		return payload, "", nil
+	case Blob:
+		payload = make([]byte, len(vv.Data))
+		copy(payload, vv.Data)
+
+		return payload, vv.ContentType, nil
+	case *Blob:
+		if vv == nil {
+			return nil, "", nil
+		}
+
+		return blobPayload(*vv)
+	case io.Reader:
+		defer closeReader(vv)
+
+		if payload, err = ioutil.ReadAll(vv); err != nil {
+			return nil, "", err
+		}
+
+		return payload, "", nil
+	case json.Marshaler:
+		if payload, err = vv.MarshalJSON(); err != nil {
+			return nil, "", err
+		}
+
+		return payload, "application/json", nil
+	case fmt.Stringer:
+		return []byte(vv.String()), "", nil
+	default:
+		if payload, err = json.Marshal(vv); err != nil {
+			return nil, "", err
+		}
+
+		return payload, "application/json", nil
+	}
+}
+
+// readBlobHead reads the reader up to the limit. If the reader has more data,
+// the rest is returned as body, so it could be streamed later.
+func readBlobHead(r io.Reader, limit int64) (head []byte, body io.Reader, err error) {
+	var buf bytes.Buffer
+	if _, err = buf.ReadFrom(io.LimitReader(r, limit)); err != nil {
+		return nil, nil, err
+	}
+
+	if int64(buf.Len()) < limit {
+		closeReader(r)
+		return buf.Bytes(), nil, nil
+	}
+
+	return buf.Bytes(), r, nil
+}
+
+// closeReader closes readers passed as blobs once they are consumed.
+func closeReader(r io.Reader) {
+	if closer, ok := r.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// fieldSize returns size of string and byte slice values, other values are
+// not measured, as it would require marshalling them on every entry.
+func fieldSize(v interface{}) int {
+	switch vv := v.(type) {
+	case string:
+		return len(vv)
+	case []byte:
+		return len(vv)
+	case json.RawMessage:
+		return len(vv)
+	}
+
+	return 0
+}
+
+func copyFields(fields logrus.Fields) logrus.Fields {
+	ff := make(logrus.Fields, len(fields))
+	for k, v := range fields {
+		ff[k] = v
+	}
+
+	return ff
+}
+
+func deleteFields(fields logrus.Fields, keys []string) {
+	for _, key := range keys {
+		delete(fields, key)
+	}
+}
@@ -0,0 +1,117 @@
+package blob
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// PrettyPrint indents JSON and HTML blobs, other blobs are returned as is.
+func PrettyPrint(blob *Blob) []byte {
+	var buf bytes.Buffer
+
+	switch {
+	case strings.Contains(blob.ContentType, "json"):
+		if err := json.Indent(&buf, blob.Data, "", "  "); err != nil {
+			return blob.Data
+		}
+	case strings.HasPrefix(blob.ContentType, "text/html"):
+		if err := indentHTML(&buf, blob.Data); err != nil {
+			return blob.Data
+		}
+	default:
+		return blob.Data
+	}
+
+	buf.WriteByte('\n')
+
+	return buf.Bytes()
+}
+
+// voidElements have no end tags, so they don't increase the depth.
+//
+//nolint:gochecknoglobals
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// rawElements hold text which must be kept intact.
+//
+//nolint:gochecknoglobals
+var rawElements = map[string]bool{
+	"pre": true, "textarea": true, "script": true, "style": true,
+}
+
+// indentHTML puts every tag on its own line indented by its depth,
+// contents of pre, textarea, script and style elements are kept as is.
+func indentHTML(w *bytes.Buffer, data []byte) error {
+	z := html.NewTokenizer(bytes.NewReader(data))
+	depth, raw := 0, 0
+
+	line := func(s string) {
+		if w.Len() > 0 {
+			w.WriteByte('\n')
+		}
+
+		w.WriteString(strings.Repeat("  ", depth))
+		w.WriteString(s)
+	}
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if err := z.Err(); err != io.EOF {
+				return err
+			}
+
+			return nil
+		}
+
+		token := string(z.Raw())
+
+		if raw > 0 {
+			w.WriteString(token)
+
+			if name, _ := z.TagName(); !rawElements[string(name)] {
+				continue
+			} else if tt == html.StartTagToken {
+				raw++
+			} else if tt == html.EndTagToken {
+				raw--
+				depth--
+			}
+
+			continue
+		}
+
+		switch tt {
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			line(token)
+
+			if rawElements[string(name)] {
+				raw++
+				depth++
+			} else if !voidElements[string(name)] {
+				depth++
+			}
+		case html.EndTagToken:
+			if depth > 0 {
+				depth--
+			}
+
+			line(token)
+		case html.TextToken:
+			if text := strings.TrimSpace(token); len(text) > 0 {
+				line(text)
+			}
+		default:
+			line(token)
+		}
+	}
+}
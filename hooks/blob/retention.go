@@ -0,0 +1,90 @@
+package blob
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LifecycleInstaller is implemented by stores that support native object
+// expiration, such as S3 buckets with lifecycle rules.
+type LifecycleInstaller interface {
+	// InstallLifecycleRule makes the store delete objects under the prefix after ttl.
+	InstallLifecycleRule(prefix string, ttl time.Duration) error
+}
+
+// ExpiredObjectsDeleter is implemented by stores that have no native object
+// lifecycle, so expired objects have to be swept explicitly.
+type ExpiredObjectsDeleter interface {
+	// DeleteExpired deletes objects expired by the time, returning their amount.
+	DeleteExpired(now time.Time) (int, error)
+}
+
+// DefaultSweepInterval is the default interval between sweeps of expired blobs.
+const DefaultSweepInterval = time.Hour
+
+// Sweeper periodically deletes expired objects from a store.
+type Sweeper struct {
+	store    ExpiredObjectsDeleter
+	interval time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewSweeper starts sweeping the store, the first sweep is done immediately.
+func NewSweeper(store ExpiredObjectsDeleter, interval time.Duration) *Sweeper {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+
+	s := &Sweeper{
+		store:    store,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go s.loop()
+
+	return s
+}
+
+func (s *Sweeper) loop() {
+	defer close(s.done)
+
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+
+	for {
+		s.Sweep()
+
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Sweep deletes expired objects once.
+func (s *Sweeper) Sweep() {
+	if n, err := s.store.DeleteExpired(time.Now()); err != nil {
+		logrus.WithError(err).Warningln("failed to sweep expired blobs")
+	} else if n > 0 {
+		logrus.WithField("count", n).Debugln("swept expired blobs")
+	}
+}
+
+// Close stops sweeping.
+func (s *Sweeper) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	<-s.done
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package blob
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Rule selects log entries whose blobs are uploaded. An entry matches the rule
+// if it matches all conditions of the rule, a rule without conditions matches
+// any entry.
+type Rule struct {
+	// Env limits the rule to the env.
+	Env string
+	// Levels limits the rule to entries of the levels, see AtLeast.
+	Levels []logrus.Level
+	// Fields limits the rule to entries having the fields set to the values,
+	// values of the entry fields are formatted with fmt.Sprint to compare.
+	Fields map[string]string
+	// SampleRate is the share of the matching entries whose blobs are uploaded,
+	// e.g. 0.1 for 10%. Blobs of all matching entries are uploaded if it's zero.
+	SampleRate float64
+}
+
+// AtLeast returns the level and all levels that are more severe, e.g.
+// AtLeast(logrus.WarnLevel) returns the warning, error, fatal and panic levels.
+func AtLeast(level logrus.Level) []logrus.Level {
+	var levels []logrus.Level
+
+	for _, l := range logrus.AllLevels {
+		if l <= level {
+			levels = append(levels, l)
+		}
+	}
+
+	return levels
+}
+
+// ParseRules parses rules separated by semicolons, each rule is a space-separated
+// list of conditions: env=<env>, level=<least severe level>, sample=<rate> and
+// <field>=<value> for other fields, e.g. "env=staging; env=prod level=error".
+// The sample rate must be above 0 and at most 1, entries are skipped by not
+// matching any rule rather than by the zero rate.
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+
+	for _, ruleText := range strings.Split(s, ";") {
+		conditions := strings.Fields(ruleText)
+		if len(conditions) == 0 {
+			continue
+		}
+
+		var rule Rule
+
+		for _, cond := range conditions {
+			parts := strings.SplitN(cond, "=", 2)
+			if len(parts) != 2 || len(parts[0]) == 0 {
+				return nil, fmt.Errorf("invalid blob rule condition: %q", cond)
+			}
+
+			name, value := parts[0], parts[1]
+
+			switch name {
+			case "env":
+				rule.Env = value
+			case "level":
+				level, err := logrus.ParseLevel(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid blob rule level: %w", err)
+				}
+
+				rule.Levels = AtLeast(level)
+			case "sample":
+				rate, err := strconv.ParseFloat(value, 64)
+				if err != nil || !(rate > 0 && rate <= 1) {
+					return nil, fmt.Errorf("invalid blob rule sample rate: %q", value)
+				}
+
+				rule.SampleRate = rate
+			default:
+				if rule.Fields == nil {
+					rule.Fields = make(map[string]string)
+				}
+
+				rule.Fields[name] = value
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Match reports whether the entry logged in the env matches the rule conditions.
+// The sample rate is not taken into account.
+func (r *Rule) Match(env string, e *logrus.Entry) bool {
+	if len(r.Env) > 0 && r.Env != env {
+		return false
+	}
+
+	if len(r.Levels) > 0 {
+		var levelMatched bool
+
+		for _, level := range r.Levels {
+			if level == e.Level {
+				levelMatched = true
+				break
+			}
+		}
+
+		if !levelMatched {
+			return false
+		}
+	}
+
+	for field, value := range r.Fields {
+		v, ok := e.Data[field]
+		if !ok || fmt.Sprint(v) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sample reports whether the matching entry is picked by the sample rate.
+func (r *Rule) sample() bool {
+	if r.SampleRate <= 0 || r.SampleRate >= 1 {
+		return true
+	}
+
+	return globalRand.Float64() < r.SampleRate
+}
+
+// matchRules reports whether blobs of the entry should be uploaded. The first
+// rule the entry matches decides, blobs of the entries that don't match any rule
+// are not uploaded. Blobs of all entries are uploaded if there are no rules.
+func (h *hook) matchRules(e *logrus.Entry) bool {
+	if len(h.opt.Rules) == 0 {
+		return true
+	}
+
+	for i := range h.opt.Rules {
+		if rule := &h.opt.Rules[i]; rule.Match(h.opt.Env, e) {
+			return rule.sample()
+		}
+	}
+
+	return false
+}
@@ -2,11 +2,16 @@ package blob
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"math"
+	"net/http"
+	"net/url"
 	"path"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -18,41 +23,121 @@ type S3Remote interface {
 	PutObject(key string, r io.Reader, meta map[string]string) (*S3Spec, error)
 }
 
+// S3RemoteOptions allows to tune uploads of S3 remote.
+type S3RemoteOptions struct {
+	// MultipartThreshold is the object size starting from which the object
+	// is streamed to the bucket using a multipart upload.
+	MultipartThreshold int64
+	// MultipartPartSize is the size of each part of a multipart upload, at least 5MB.
+	MultipartPartSize int64
+	// PathStyle makes the bucket addressed in the URL path rather than in the host
+	// name, it's required by some S3 compatible servers such as MinIO.
+	PathStyle bool
+	// TagExpires tags objects having expiration time with output-expires, so the time
+	// could be used by bucket tooling. It requires the s3:PutObjectTagging permission.
+	TagExpires bool
+}
+
+const (
+	// DefaultMultipartThreshold is the default object size starting
+	// from which the object is uploaded in parts.
+	DefaultMultipartThreshold = 32 << 20
+	// DefaultMultipartPartSize is the default size of each part of a multipart upload.
+	DefaultMultipartPartSize = 8 << 20
+	// MinMultipartPartSize is the smallest part size accepted by S3.
+	MinMultipartPartSize = 5 << 20
+)
+
 func NewS3Remote(accoutID, secretKey, endpoint, region, bucket string) (s3Client S3Remote, err error) {
+	store, err := newS3Store(accoutID, secretKey, endpoint, region, bucket, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s3Client = &s3Remote{
+		store: store,
+	}
+
+	return s3Client, nil
+}
+
+// NewS3Store initializes a BlobStore backed by an Amazon S3 compatible bucket,
+// allowing to tune uploads. The store is also a Presigner and a LifecycleInstaller.
+func NewS3Store(
+	accoutID, secretKey, endpoint, region, bucket string,
+	opt *S3RemoteOptions,
+) (BlobStore, error) {
+	store, err := newS3Store(accoutID, secretKey, endpoint, region, bucket, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func newS3Store(
+	accoutID, secretKey, endpoint, region, bucket string,
+	opt *S3RemoteOptions,
+) (*s3Store, error) {
+	opt = checkS3RemoteOptions(opt)
+
 	sess, err := session.NewSession(&aws.Config{
-		Credentials: credentials.NewStaticCredentials(accoutID, secretKey, ""),
-		Endpoint:    aws.String(endpoint),
-		Region:      aws.String(region),
+		Credentials:      credentials.NewStaticCredentials(accoutID, secretKey, ""),
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String(region),
+		S3ForcePathStyle: aws.Bool(opt.PathStyle),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	s3Client = &s3Remote{
+	return &s3Store{
 		bucket: bucket,
 		cli:    s3.New(sess),
+		opt:    opt,
+	}, nil
+}
+
+func checkS3RemoteOptions(opt *S3RemoteOptions) *S3RemoteOptions {
+	if opt == nil {
+		opt = &S3RemoteOptions{}
 	}
 
-	return s3Client, nil
-}
+	if opt.MultipartThreshold <= 0 {
+		opt.MultipartThreshold = DefaultMultipartThreshold
+	}
+
+	if opt.MultipartPartSize <= 0 {
+		opt.MultipartPartSize = DefaultMultipartPartSize
+	} else if opt.MultipartPartSize < MinMultipartPartSize {
+		opt.MultipartPartSize = MinMultipartPartSize
+	}
 
-type S3Spec struct {
-	Path      string
-	Key       string
-	Body      io.ReadCloser
-	ETag      string
-	Version   string
-	UpdatedAt time.Time
-	Meta      map[string]string
-	Size      int64
+	return opt
 }
 
+// s3Remote adapts the S3 store to the S3Remote interface.
 type s3Remote struct {
+	store *s3Store
+}
+
+func (s *s3Remote) CheckAccess(key string) error {
+	return s.store.CheckAccess(key)
+}
+
+func (s *s3Remote) PutObject(key string, r io.Reader, meta map[string]string) (*S3Spec, error) {
+	return s.store.PutObject(key, r, &PutOptions{
+		Meta: meta,
+	})
+}
+
+type s3Store struct {
 	bucket string
 	cli    *s3.S3
+	opt    *S3RemoteOptions
 }
 
-func (s *s3Remote) CheckAccess(prefix string) error {
+func (s *s3Store) CheckAccess(prefix string) error {
 	body := []byte(time.Now().UTC().String())
 	_, err := s.cli.PutObject(&s3.PutObjectInput{
 		Body:        aws.ReadSeekCloser(bytes.NewReader(body)),
@@ -64,23 +149,230 @@ func (s *s3Remote) CheckAccess(prefix string) error {
 	return err
 }
 
-func (s *s3Remote) PutObject(key string, r io.Reader, meta map[string]string) (*S3Spec, error) {
-	obj, err := s.cli.PutObject(&s3.PutObjectInput{
-		Body:     aws.ReadSeekCloser(r),
-		Bucket:   aws.String(s.bucket),
-		Key:      aws.String(key),
-		Metadata: aws.StringMap(meta),
+// PutObject uploads the object in a single request if its size is below
+// the multipart threshold, otherwise the object is streamed in parts.
+func (s *s3Store) PutObject(key string, r io.Reader, opt *PutOptions) (*ObjectSpec, error) {
+	if opt == nil {
+		opt = &PutOptions{}
+	}
+
+	body, ok := r.(io.ReadSeeker)
+	if size, known := readerSize(r); !ok || !known || size >= s.opt.MultipartThreshold {
+		// read up to the threshold, to find out whether the object is small enough
+		var head bytes.Buffer
+		if _, err := head.ReadFrom(io.LimitReader(r, s.opt.MultipartThreshold)); err != nil {
+			return nil, err
+		}
+
+		if int64(head.Len()) >= s.opt.MultipartThreshold {
+			return s.putMultipart(key, io.MultiReader(&head, r), opt)
+		}
+
+		body = bytes.NewReader(head.Bytes())
+	}
+
+	input := &s3.PutObjectInput{
+		Body:            body,
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		Metadata:        aws.StringMap(opt.Meta),
+		ContentType:     stringOrNil(opt.ContentType),
+		ContentEncoding: stringOrNil(opt.ContentEncoding),
+	}
+
+	if !opt.Expires.IsZero() {
+		input.Expires = aws.Time(opt.Expires)
+
+		if s.opt.TagExpires {
+			input.Tagging = expiresTagging(opt.Expires)
+		}
+	}
+
+	obj, err := s.cli.PutObject(input)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := specFromOptions(key, opt)
+	spec.ETag = aws.StringValue(obj.ETag)
+	spec.Version = aws.StringValue(obj.VersionId)
+
+	return spec, err
+}
+
+func (s *s3Store) HeadObject(key string) (*ObjectSpec, error) {
+	obj, err := s.cli.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, mapS3Error(err)
+	}
+
+	spec := &ObjectSpec{
+		Key:             key,
+		ETag:            aws.StringValue(obj.ETag),
+		Version:         aws.StringValue(obj.VersionId),
+		UpdatedAt:       aws.TimeValue(obj.LastModified),
+		Meta:            aws.StringValueMap(obj.Metadata),
+		Size:            aws.Int64Value(obj.ContentLength),
+		ContentType:     aws.StringValue(obj.ContentType),
+		ContentEncoding: aws.StringValue(obj.ContentEncoding),
+	}
+
+	if expires := aws.StringValue(obj.Expires); len(expires) > 0 {
+		spec.Expires, _ = http.ParseTime(expires)
+	}
+
+	return spec, nil
+}
+
+func (s *s3Store) GetObject(key string) (*ObjectSpec, error) {
+	obj, err := s.cli.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, mapS3Error(err)
+	}
+
+	spec := &ObjectSpec{
+		Key:             key,
+		Body:            obj.Body,
+		ETag:            aws.StringValue(obj.ETag),
+		Version:         aws.StringValue(obj.VersionId),
+		UpdatedAt:       aws.TimeValue(obj.LastModified),
+		Meta:            aws.StringValueMap(obj.Metadata),
+		Size:            aws.Int64Value(obj.ContentLength),
+		ContentType:     aws.StringValue(obj.ContentType),
+		ContentEncoding: aws.StringValue(obj.ContentEncoding),
+	}
+
+	if expires := aws.StringValue(obj.Expires); len(expires) > 0 {
+		spec.Expires, _ = http.ParseTime(expires)
+	}
+
+	return spec, nil
+}
+
+func (s *s3Store) ListObjects(opt *ListOptions) ([]*ObjectSpec, error) {
+	if opt == nil {
+		opt = &ListOptions{}
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(opt.Prefix),
+	}
+
+	if len(opt.StartAfter) > 0 {
+		input.StartAfter = aws.String(opt.StartAfter)
+	}
+
+	var specs []*ObjectSpec
+
+	err := s.cli.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			if opt.Limit > 0 && len(specs) >= opt.Limit {
+				return false
+			}
+
+			specs = append(specs, &ObjectSpec{
+				Key:       aws.StringValue(obj.Key),
+				ETag:      aws.StringValue(obj.ETag),
+				UpdatedAt: aws.TimeValue(obj.LastModified),
+				Size:      aws.Int64Value(obj.Size),
+			})
+		}
+
+		return opt.Limit <= 0 || len(specs) < opt.Limit
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	spec := &S3Spec{
-		Key:     key,
-		ETag:    aws.StringValue(obj.ETag),
-		Version: aws.StringValue(obj.VersionId),
-		Meta:    meta,
+	return specs, nil
+}
+
+// PresignGetObject returns a URL granting read access to the object for ttl,
+// which is limited to 7 days by S3.
+func (s *s3Store) PresignGetObject(key string, ttl time.Duration) (string, error) {
+	req, _ := s.cli.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+
+	return req.Presign(ttl)
+}
+
+// mapS3Error converts S3 errors about missing objects into ErrNotFound.
+func mapS3Error(err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return ErrNotFound
+		}
 	}
 
-	return spec, err
+	return err
+}
+
+// tagExpires is the object tag holding expiration time of the object.
+const tagExpires = "output-expires"
+
+func expiresTagging(expires time.Time) *string {
+	return aws.String(url.Values{
+		tagExpires: []string{expires.UTC().Format(time.RFC3339)},
+	}.Encode())
+}
+
+func stringOrNil(v string) *string {
+	if len(v) == 0 {
+		return nil
+	}
+
+	return aws.String(v)
+}
+
+// InstallLifecycleRule adds or replaces the bucket lifecycle rule that expires
+// objects under the prefix, other rules of the bucket are kept intact.
+func (s *s3Store) InstallLifecycleRule(prefix string, ttl time.Duration) error {
+	ruleID := fmt.Sprintf("output-blob-retention-%s", prefix)
+	days := int64(math.Ceil(ttl.Hours() / 24))
+
+	rules := []*s3.LifecycleRule{{
+		ID:     aws.String(ruleID),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{
+			Prefix: aws.String(prefix + "/"),
+		},
+		Expiration: &s3.LifecycleExpiration{
+			Days: aws.Int64(days),
+		},
+	}}
+
+	current, err := s.cli.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(s.bucket),
+	})
+	if err != nil {
+		// the bucket has no lifecycle configuration yet
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NoSuchLifecycleConfiguration" {
+			return err
+		}
+	} else {
+		for _, rule := range current.Rules {
+			if aws.StringValue(rule.ID) != ruleID {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	_, err = s.cli.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(s.bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: rules,
+		},
+	})
+
+	return err
 }
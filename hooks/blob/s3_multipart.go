@@ -0,0 +1,155 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// maxMultipartParts is the maximum amount of parts of an S3 multipart upload.
+const maxMultipartParts = 10000
+
+// putMultipart streams the object to the bucket part by part, so only a single
+// part is kept in memory. Each part is sent with its MD5 checksum to be verified
+// by S3, the upload is aborted on any failure, so no orphaned parts are left.
+func (s *s3Store) putMultipart(key string, r io.Reader, opt *PutOptions) (*ObjectSpec, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		Metadata:        aws.StringMap(opt.Meta),
+		ContentType:     stringOrNil(opt.ContentType),
+		ContentEncoding: stringOrNil(opt.ContentEncoding),
+	}
+
+	if !opt.Expires.IsZero() {
+		input.Expires = aws.Time(opt.Expires)
+
+		if s.opt.TagExpires {
+			input.Tagging = expiresTagging(opt.Expires)
+		}
+	}
+
+	upload, err := s.cli.CreateMultipartUpload(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	parts, size, err := s.uploadParts(upload.UploadId, key, r)
+	if err != nil {
+		s.abortMultipart(upload.UploadId, key)
+		return nil, err
+	}
+
+	obj, err := s.cli.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: parts,
+		},
+	})
+	if err != nil {
+		s.abortMultipart(upload.UploadId, key)
+		return nil, fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	spec := specFromOptions(key, opt)
+	spec.ETag = aws.StringValue(obj.ETag)
+	spec.Version = aws.StringValue(obj.VersionId)
+	spec.Size = size
+
+	return spec, nil
+}
+
+func (s *s3Store) uploadParts(uploadID *string, key string, r io.Reader) ([]*s3.CompletedPart, int64, error) {
+	var parts []*s3.CompletedPart
+
+	var size int64
+
+	buf := make([]byte, s.opt.MultipartPartSize)
+
+	for partNumber := int64(1); ; partNumber++ {
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return nil, 0, fmt.Errorf("failed to read part %d: %w", partNumber, readErr)
+		}
+
+		// the first part is always uploaded, even if empty
+		if n == 0 && partNumber > 1 {
+			break
+		} else if partNumber > maxMultipartParts {
+			return nil, 0, fmt.Errorf("object exceeds %d parts, increase the part size", maxMultipartParts)
+		}
+
+		part := buf[:n]
+		sum := md5.Sum(part) //nolint:gosec
+
+		obj, err := s.cli.UploadPart(&s3.UploadPartInput{
+			Body:       bytes.NewReader(part),
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(key),
+			UploadId:   uploadID,
+			PartNumber: aws.Int64(partNumber),
+			ContentMD5: aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+		}
+
+		parts = append(parts, &s3.CompletedPart{
+			ETag:       obj.ETag,
+			PartNumber: aws.Int64(partNumber),
+		})
+		size += int64(n)
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	return parts, size, nil
+}
+
+func (s *s3Store) abortMultipart(uploadID *string, key string) {
+	if _, err := s.cli.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	}); err != nil {
+		logrus.WithError(err).WithField("key", key).Warningln("failed to abort multipart upload")
+	}
+}
+
+// readerSize returns the amount of bytes left in the reader, if it's known.
+func readerSize(r io.Reader) (int64, bool) {
+	switch rr := r.(type) {
+	case *bytes.Reader:
+		return int64(rr.Len()), true
+	case *bytes.Buffer:
+		return int64(rr.Len()), true
+	case *strings.Reader:
+		return int64(rr.Len()), true
+	case *os.File:
+		info, err := rr.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return 0, false
+		}
+
+		offset, err := rr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+
+		return info.Size() - offset, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,553 @@
+// Package s3test provides an in-process S3 compatible server for tests of the
+// blob hook, so they don't depend on real credentials and network buckets.
+//
+// The server implements the subset of the S3 API used by the blob hook: PutObject,
+// GetObject, HeadObject, DeleteObject, ListObjectsV2, multipart uploads and bucket
+// lifecycle configuration. Buckets are addressed in the URL path, request
+// signatures are not verified.
+package s3test
+
+import (
+	"crypto/md5" //nolint:gosec
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Region is the region the server pretends to be in.
+const Region = "us-east-1"
+
+// Object is an object stored by the server.
+type Object struct {
+	Key             string
+	Body            []byte
+	ETag            string
+	ContentType     string
+	ContentEncoding string
+	Expires         string
+	Tagging         string
+	Meta            map[string]string
+	LastModified    time.Time
+}
+
+type bucket struct {
+	objects   map[string]*Object
+	lifecycle []byte
+}
+
+type multipartUpload struct {
+	bucket string
+	object *Object
+	parts  map[int][]byte
+}
+
+// Server is an S3 compatible HTTP server keeping objects in memory.
+type Server struct {
+	*httptest.Server
+
+	mux      sync.Mutex
+	buckets  map[string]*bucket
+	uploads  map[string]*multipartUpload
+	uploadID int
+}
+
+// NewServer starts a server with the buckets created. Close the server when done.
+func NewServer(buckets ...string) *Server {
+	s := &Server{
+		buckets: make(map[string]*bucket),
+		uploads: make(map[string]*multipartUpload),
+	}
+
+	for _, name := range buckets {
+		s.CreateBucket(name)
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// CreateBucket creates an empty bucket, if it doesn't exist yet.
+func (s *Server) CreateBucket(name string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.buckets[name]; !ok {
+		s.buckets[name] = &bucket{
+			objects: make(map[string]*Object),
+		}
+	}
+}
+
+// Object returns a copy of the object stored in the bucket.
+func (s *Server) Object(bucketName, key string) (*Object, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, false
+	}
+
+	obj, ok := b.objects[key]
+	if !ok {
+		return nil, false
+	}
+
+	objCopy := *obj
+
+	return &objCopy, true
+}
+
+// Keys returns sorted keys of the objects stored in the bucket.
+func (s *Server) Keys(bucketName string) []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil
+	}
+
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Lifecycle returns the lifecycle configuration XML of the bucket.
+func (s *Server) Lifecycle(bucketName string) []byte {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if b, ok := s.buckets[bucketName]; ok {
+		return b.lifecycle
+	}
+
+	return nil
+}
+
+// Uploads returns the amount of multipart uploads that are neither completed nor aborted.
+func (s *Server) Uploads() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return len(s.uploads)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	bucketName, key := splitPath(r.URL.Path)
+	query := r.URL.Query()
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	if len(key) == 0 {
+		switch {
+		case r.Method == http.MethodGet && query.Get("list-type") == "2":
+			s.listObjects(w, r, bucketName, b)
+		case r.Method == http.MethodGet && hasQuery(query, "lifecycle"):
+			s.getLifecycle(w, b)
+		case r.Method == http.MethodPut && hasQuery(query, "lifecycle"):
+			s.putLifecycle(w, r, b)
+		default:
+			writeError(w, http.StatusNotImplemented, "NotImplemented", "The bucket operation is not implemented")
+		}
+
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && hasQuery(query, "uploads"):
+		s.createMultipartUpload(w, r, bucketName, key)
+	case r.Method == http.MethodPut && len(query.Get("uploadId")) > 0:
+		s.uploadPart(w, r, query)
+	case r.Method == http.MethodPost && len(query.Get("uploadId")) > 0:
+		s.completeMultipartUpload(w, r, b, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && len(query.Get("uploadId")) > 0:
+		s.abortMultipartUpload(w, query.Get("uploadId"))
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, b, key)
+	case r.Method == http.MethodGet:
+		s.getObject(w, b, key, true)
+	case r.Method == http.MethodHead:
+		s.getObject(w, b, key, false)
+	case r.Method == http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "The object operation is not implemented")
+	}
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	} else if !checkContentMD5(w, r, body) {
+		return
+	}
+
+	obj := objectFromRequest(r, key)
+	obj.Body = body
+	obj.ETag = etag(body)
+	b.objects[key] = obj
+
+	w.Header().Set("ETag", obj.ETag)
+}
+
+func (s *Server) getObject(w http.ResponseWriter, b *bucket, key string, withBody bool) {
+	obj, ok := b.objects[key]
+	if !ok {
+		if !withBody {
+			// HEAD responses have no body, so the error code can't be sent
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+
+		return
+	}
+
+	h := w.Header()
+	h.Set("ETag", obj.ETag)
+	h.Set("Last-Modified", obj.LastModified.Format(http.TimeFormat))
+	h.Set("Content-Length", strconv.Itoa(len(obj.Body)))
+
+	if len(obj.ContentType) > 0 {
+		h.Set("Content-Type", obj.ContentType)
+	}
+
+	if len(obj.ContentEncoding) > 0 {
+		h.Set("Content-Encoding", obj.ContentEncoding)
+	}
+
+	if len(obj.Expires) > 0 {
+		h.Set("Expires", obj.Expires)
+	}
+
+	for name, value := range obj.Meta {
+		h.Set("X-Amz-Meta-"+name, value)
+	}
+
+	if withBody {
+		w.Write(obj.Body) //nolint:errcheck
+	}
+}
+
+type listEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name    `xml:"ListBucketResult"`
+	Name                  string      `xml:"Name"`
+	Prefix                string      `xml:"Prefix"`
+	StartAfter            string      `xml:"StartAfter,omitempty"`
+	ContinuationToken     string      `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string      `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int         `xml:"KeyCount"`
+	MaxKeys               int         `xml:"MaxKeys"`
+	IsTruncated           bool        `xml:"IsTruncated"`
+	Contents              []listEntry `xml:"Contents"`
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucketName string, b *bucket) {
+	query := r.URL.Query()
+	res := &listBucketResult{
+		Name:              bucketName,
+		Prefix:            query.Get("prefix"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           1000,
+	}
+
+	if maxKeys, err := strconv.Atoi(query.Get("max-keys")); err == nil && maxKeys < res.MaxKeys {
+		res.MaxKeys = maxKeys
+	}
+
+	after := res.StartAfter
+	if len(res.ContinuationToken) > 0 {
+		after = res.ContinuationToken
+	}
+
+	keys := make([]string, 0, len(b.objects))
+
+	for key := range b.objects {
+		if strings.HasPrefix(key, res.Prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	if len(keys) > res.MaxKeys {
+		keys = keys[:res.MaxKeys]
+		res.IsTruncated = true
+		res.NextContinuationToken = keys[len(keys)-1]
+	}
+
+	for _, key := range keys {
+		obj := b.objects[key]
+		res.Contents = append(res.Contents, listEntry{
+			Key:          key,
+			LastModified: obj.LastModified.Format(time.RFC3339Nano),
+			ETag:         obj.ETag,
+			Size:         len(obj.Body),
+			StorageClass: "STANDARD",
+		})
+	}
+
+	res.KeyCount = len(res.Contents)
+
+	writeXML(w, res)
+}
+
+func (s *Server) getLifecycle(w http.ResponseWriter, b *bucket) {
+	if len(b.lifecycle) == 0 {
+		writeError(w, http.StatusNotFound, "NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(b.lifecycle) //nolint:errcheck
+}
+
+func (s *Server) putLifecycle(w http.ResponseWriter, r *http.Request, b *bucket) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	b.lifecycle = body
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	s.uploadID++
+	uploadID := strconv.Itoa(s.uploadID)
+
+	s.uploads[uploadID] = &multipartUpload{
+		bucket: bucketName,
+		object: objectFromRequest(r, key),
+		parts:  make(map[int][]byte),
+	}
+
+	writeXML(w, &initiateMultipartUploadResult{
+		Bucket:   bucketName,
+		Key:      key,
+		UploadID: uploadID,
+	})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, query map[string][]string) {
+	upload, ok := s.uploads[first(query["uploadId"])]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+
+	partNumber, err := strconv.Atoi(first(query["partNumber"]))
+	if err != nil || partNumber < 1 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Part number must be a positive integer")
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	} else if !checkContentMD5(w, r, body) {
+		return
+	}
+
+	upload.parts[partNumber] = body
+
+	w.Header().Set("ETag", etag(body))
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, b *bucket, uploadID string) {
+	upload, ok := s.uploads[uploadID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	var body, sums []byte
+
+	for i, part := range req.Parts {
+		data, ok := upload.parts[part.PartNumber]
+		if !ok || part.ETag != etag(data) {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("Part %d is not found", part.PartNumber))
+			return
+		} else if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+			writeError(w, http.StatusBadRequest, "InvalidPartOrder", "Parts must be in ascending order")
+			return
+		}
+
+		sum := md5.Sum(data) //nolint:gosec
+		sums = append(sums, sum[:]...)
+		body = append(body, data...)
+	}
+
+	sum := md5.Sum(sums) //nolint:gosec
+
+	obj := upload.object
+	obj.Body = body
+	obj.ETag = fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(req.Parts))
+	b.objects[obj.Key] = obj
+
+	delete(s.uploads, uploadID)
+
+	writeXML(w, &completeMultipartUploadResult{
+		Bucket: upload.bucket,
+		Key:    obj.Key,
+		ETag:   obj.ETag,
+	})
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, uploadID string) {
+	if _, ok := s.uploads[uploadID]; !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+
+	delete(s.uploads, uploadID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func objectFromRequest(r *http.Request, key string) *Object {
+	obj := &Object{
+		Key:             key,
+		ContentType:     r.Header.Get("Content-Type"),
+		ContentEncoding: r.Header.Get("Content-Encoding"),
+		Expires:         r.Header.Get("Expires"),
+		Tagging:         r.Header.Get("X-Amz-Tagging"),
+		Meta:            make(map[string]string),
+		LastModified:    time.Now().UTC(),
+	}
+
+	for name, values := range r.Header {
+		if meta := strings.TrimPrefix(name, "X-Amz-Meta-"); meta != name {
+			obj.Meta[strings.ToLower(meta)] = first(values)
+		}
+	}
+
+	return obj
+}
+
+// checkContentMD5 verifies the body checksum, if the client has sent it.
+func checkContentMD5(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	contentMD5 := r.Header.Get("Content-MD5")
+	if len(contentMD5) == 0 {
+		return true
+	}
+
+	sum := md5.Sum(body) //nolint:gosec
+	if contentMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+		writeError(w, http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what was received")
+		return false
+	}
+
+	return true
+}
+
+func splitPath(urlPath string) (bucketName, key string) {
+	parts := strings.SplitN(strings.TrimPrefix(urlPath, "/"), "/", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+
+	return parts[0], ""
+}
+
+func hasQuery(query map[string][]string, name string) bool {
+	_, ok := query[name]
+	return ok
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func etag(body []byte) string {
+	sum := md5.Sum(body) //nolint:gosec
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(&errorResponse{ //nolint:errcheck
+		Code:    code,
+		Message: message,
+	})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header)) //nolint:errcheck
+	xml.NewEncoder(w).Encode(v) //nolint:errcheck
+}
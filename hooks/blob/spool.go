@@ -0,0 +1,421 @@
+package blob
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SpoolStats contains metrics of the local spool of failed uploads.
+type SpoolStats struct {
+	// Files is the amount of blobs currently waiting in the spool, including ones
+	// spooled by other processes sharing the spool dir.
+	Files int
+	// Bytes is the disk space currently used by spooled blobs.
+	Bytes int64
+	// Spooled is the total amount of blobs put into the spool.
+	Spooled uint64
+	// Uploaded is the total amount of spooled blobs uploaded on retry.
+	Uploaded uint64
+	// Rejected is the total amount of blobs lost because the spool was full.
+	Rejected uint64
+}
+
+var errSpoolFull = errors.New("blob spool is full")
+
+const (
+	spoolBlobSuffix   = ".blob"
+	spoolRecordSuffix = ".json"
+	// spoolTempSuffix marks files being written, they are renamed when complete.
+	spoolTempSuffix = ".tmp"
+	// spoolQuarantineDir keeps spooled blobs whose records can't be read.
+	spoolQuarantineDir = "quarantine"
+	// spoolTempMaxAge is the age of temp files that are considered left by crashed processes.
+	spoolTempMaxAge = time.Hour
+)
+
+// spoolRecord is persisted next to each spooled blob payload.
+type spoolRecord struct {
+	Key         string      `json:"key"`
+	Options     *PutOptions `json:"options,omitempty"`
+	Attempts    int         `json:"attempts"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	NextAttempt time.Time   `json:"nextAttempt"`
+}
+
+// spool keeps blobs that failed to upload in a local directory
+// and retries uploading them with exponential backoff.
+type spool struct {
+	dir        string
+	maxBytes   int64
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	upload     func(job *uploadJob) error
+
+	// files and bytes are counted from the dir contents on every retry, as the dir could be
+	// shared by processes of the env, each spooling and uploading blobs of the others
+	mux   sync.Mutex
+	files int
+	bytes int64
+
+	spooled  uint64
+	uploaded uint64
+	rejected uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// newSpool opens the spool directory and starts retrying blobs that
+// are left there, including ones spooled before the process restart.
+func newSpool(
+	dir string,
+	maxBytes int64,
+	minBackoff, maxBackoff time.Duration,
+	upload func(job *uploadJob) error,
+) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create blob spool dir: %w", err)
+	}
+
+	s := &spool{
+		dir:        dir,
+		maxBytes:   maxBytes,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		upload:     upload,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	s.removeStaleTemp()
+
+	if _, err := s.scan(); err != nil {
+		return nil, err
+	}
+
+	go s.retryLoop()
+
+	return s, nil
+}
+
+// Put stores the job payload in the spool, so it could be retried later.
+func (s *spool) Put(job *uploadJob) error {
+	size := int64(len(job.payload))
+
+	s.mux.Lock()
+	if s.maxBytes > 0 && s.bytes+size > s.maxBytes {
+		s.mux.Unlock()
+		atomic.AddUint64(&s.rejected, 1)
+
+		return errSpoolFull
+	}
+
+	s.files++
+	s.bytes += size
+	s.mux.Unlock()
+
+	id := NewBlobID()
+	now := time.Now().UTC()
+	rec := &spoolRecord{
+		Key:         job.key,
+		Options:     job.opt,
+		CreatedAt:   now,
+		NextAttempt: now.Add(s.minBackoff),
+	}
+
+	if err := s.write(id, job.payload, rec); err != nil {
+		s.forget(size)
+		return err
+	}
+
+	atomic.AddUint64(&s.spooled, 1)
+
+	return nil
+}
+
+// Stats returns spool metrics.
+func (s *spool) Stats() SpoolStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return SpoolStats{
+		Files:    s.files,
+		Bytes:    s.bytes,
+		Spooled:  atomic.LoadUint64(&s.spooled),
+		Uploaded: atomic.LoadUint64(&s.uploaded),
+		Rejected: atomic.LoadUint64(&s.rejected),
+	}
+}
+
+// Close stops retrying, spooled blobs are kept on disk for the next run.
+func (s *spool) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	<-s.done
+
+	return nil
+}
+
+// write stores the payload and then the record, so a spooled blob is picked up
+// for retries only when both files are complete.
+func (s *spool) write(id string, payload []byte, rec *spoolRecord) error {
+	if err := writeFileAtomic(s.blobPath(id), payload); err != nil {
+		return err
+	}
+
+	if err := s.writeRecord(id, rec); err != nil {
+		os.Remove(s.blobPath(id))
+		return err
+	}
+
+	return nil
+}
+
+func (s *spool) writeRecord(id string, rec *spoolRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.recordPath(id), data)
+}
+
+// writeFileAtomic writes the data into a temp file in the same dir and renames it,
+// so readers, including other processes sharing the spool dir, never see partial files.
+func writeFileAtomic(path string, data []byte) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*"+spoolTempSuffix)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	} else if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+func (s *spool) retryLoop() {
+	defer close(s.done)
+
+	t := time.NewTicker(s.minBackoff)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			s.retry()
+		}
+	}
+}
+
+// retry attempts to upload every spooled blob that is due.
+func (s *spool) retry() {
+	ids, err := s.scan()
+	if err != nil {
+		logrus.WithError(err).Warningln("failed to list blob spool dir")
+		return
+	}
+
+	for _, id := range ids {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		s.retryOne(id)
+	}
+}
+
+func (s *spool) retryOne(id string) {
+	var rec spoolRecord
+
+	data, err := ioutil.ReadFile(s.recordPath(id))
+	if err != nil {
+		return
+	} else if err := json.Unmarshal(data, &rec); err != nil {
+		logrus.WithError(err).WithField("record", s.recordPath(id)).Warningln("quarantining corrupted blob spool record")
+		s.quarantine(id)
+
+		return
+	}
+
+	if time.Now().Before(rec.NextAttempt) {
+		return
+	}
+
+	payload, err := ioutil.ReadFile(s.blobPath(id))
+	if err != nil {
+		logrus.WithError(err).WithField("key", rec.Key).Warningln("removing blob spool record without payload")
+		s.remove(id)
+
+		return
+	}
+
+	if err := s.upload(&uploadJob{key: rec.Key, payload: payload, opt: rec.Options}); err != nil {
+		rec.Attempts++
+		rec.NextAttempt = time.Now().UTC().Add(s.backoff(rec.Attempts))
+
+		if err := s.writeRecord(id, &rec); err != nil {
+			logrus.WithError(err).WithField("key", rec.Key).Warningln("failed to update blob spool record")
+		}
+
+		return
+	}
+
+	s.remove(id)
+	atomic.AddUint64(&s.uploaded, 1)
+}
+
+// backoff returns exponentially growing delay for the next attempt.
+func (s *spool) backoff(attempts int) time.Duration {
+	d := s.minBackoff
+	for i := 0; i < attempts && d < s.maxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > s.maxBackoff {
+		d = s.maxBackoff
+	}
+
+	// add up to 10% of jitter, so retries of many blobs won't come at once
+	if jitter := int64(d / 10); jitter > 0 {
+		d += time.Duration(globalRand.Int63n(jitter))
+	}
+
+	return d
+}
+
+func (s *spool) remove(id string) {
+	var size int64
+	if info, err := os.Stat(s.blobPath(id)); err == nil {
+		size = info.Size()
+	}
+
+	os.Remove(s.recordPath(id))
+
+	if err := os.Remove(s.blobPath(id)); err == nil {
+		s.forget(size)
+	}
+}
+
+// quarantine moves the blob and its record out of the spool, so they are not retried
+// but could be inspected and recovered manually.
+func (s *spool) quarantine(id string) {
+	dir := filepath.Join(s.dir, spoolQuarantineDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logrus.WithError(err).Warningln("failed to create blob spool quarantine dir")
+		return
+	}
+
+	var size int64
+	if info, err := os.Stat(s.blobPath(id)); err == nil {
+		size = info.Size()
+	}
+
+	if err := os.Rename(s.recordPath(id), filepath.Join(dir, filepath.Base(s.recordPath(id)))); err != nil {
+		// the record has been handled by another process sharing the spool dir
+		return
+	}
+
+	if err := os.Rename(s.blobPath(id), filepath.Join(dir, filepath.Base(s.blobPath(id)))); err == nil {
+		s.forget(size)
+	}
+}
+
+// removeStaleTemp removes temp files left by processes that crashed while spooling.
+func (s *spool) removeStaleTemp() {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), spoolTempSuffix) && time.Since(info.ModTime()) > spoolTempMaxAge {
+			os.Remove(filepath.Join(s.dir, info.Name()))
+		}
+	}
+}
+
+// forget updates the usage until the next scan, which counts blobs spooled and removed by other processes.
+func (s *spool) forget(size int64) {
+	s.mux.Lock()
+	if s.files > 0 {
+		s.files--
+	}
+
+	if s.bytes -= size; s.bytes < 0 {
+		s.bytes = 0
+	}
+	s.mux.Unlock()
+}
+
+// scan counts the disk space used by spooled blobs, and lists IDs of the spooled blobs,
+// oldest first.
+func (s *spool) scan() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ids   = make([]string, 0, len(infos))
+		files int
+		bytes int64
+	)
+
+	for _, info := range infos {
+		// temp files of blobs and records being written and the quarantine dir are skipped
+		switch name := info.Name(); {
+		case info.IsDir():
+		case strings.HasSuffix(name, spoolRecordSuffix):
+			ids = append(ids, strings.TrimSuffix(name, spoolRecordSuffix))
+		case strings.HasSuffix(name, spoolBlobSuffix):
+			files++
+			bytes += info.Size()
+		}
+	}
+
+	s.mux.Lock()
+	s.files = files
+	s.bytes = bytes
+	s.mux.Unlock()
+
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (s *spool) blobPath(id string) string {
+	return filepath.Join(s.dir, id+spoolBlobSuffix)
+}
+
+func (s *spool) recordPath(id string) string {
+	return filepath.Join(s.dir, id+spoolRecordSuffix)
+}
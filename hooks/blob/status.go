@@ -0,0 +1,102 @@
+package blob
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// State is the state of the blob hook.
+type State int
+
+const (
+	// StateInitializing means that access to the blob store is being checked.
+	StateInitializing State = iota
+	// StateReady means that the blob store is accessible and blobs are uploaded.
+	StateReady
+	// StateDegraded means that the blob store is not accessible, so blobs are kept
+	// in the spool until access is restored, or discarded if the spool is disabled.
+	StateDegraded
+)
+
+func (s State) String() string {
+	switch s {
+	case StateInitializing:
+		return "initializing"
+	case StateReady:
+		return "ready"
+	case StateDegraded:
+		return "degraded"
+	}
+
+	return "unknown"
+}
+
+// Status reports health of the blob hook.
+type Status struct {
+	State State
+	// Err is the last error of the blob store access check.
+	Err error
+	// CheckedAt is the time of the last access check.
+	CheckedAt time.Time
+}
+
+// Status returns the current status of the hook.
+func (h *hook) Status() Status {
+	h.statusMux.RLock()
+	defer h.statusMux.RUnlock()
+
+	return h.status
+}
+
+func (h *hook) setStatus(state State, err error) {
+	h.statusMux.Lock()
+	h.status = Status{
+		State:     state,
+		Err:       err,
+		CheckedAt: time.Now(),
+	}
+	h.statusMux.Unlock()
+}
+
+// isDiscarding reports whether blobs can't be uploaded nor spooled.
+func (h *hook) isDiscarding() bool {
+	return h.spool == nil && h.Status().State == StateDegraded
+}
+
+// checkAccessLoop verifies access to the blob store in background, so the hook
+// never blocks process start. Until the check succeeds, it's retried with
+// exponential backoff while the hook stays degraded.
+func (h *hook) checkAccessLoop() {
+	defer close(h.checkDone)
+
+	delay := h.opt.SpoolRetryMin
+
+	for {
+		err := h.store.CheckAccess(h.opt.Env)
+		if err == nil {
+			h.setStatus(StateReady, nil)
+			h.initOnce.Do(func() { close(h.initDone) })
+			h.initRetention()
+
+			return
+		}
+
+		if h.Status().State != StateDegraded {
+			logrus.WithError(err).Warningln("failed to verify blob store access, blob hook is degraded")
+		}
+
+		h.setStatus(StateDegraded, err)
+		h.initOnce.Do(func() { close(h.initDone) })
+
+		select {
+		case <-h.stop:
+			return
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > h.opt.SpoolRetryMax {
+			delay = h.opt.SpoolRetryMax
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"time"
+)
+
+// BlobStore is a storage backend used by the hook to keep blobs.
+type BlobStore interface {
+	// CheckAccess verifies that objects can be written under the prefix.
+	CheckAccess(prefix string) error
+	// PutObject stores data from the reader as an object with the given key.
+	PutObject(key string, r io.Reader, opt *PutOptions) (*ObjectSpec, error)
+	// HeadObject returns the object attributes, or ErrNotFound if there is no such object.
+	HeadObject(key string) (*ObjectSpec, error)
+	// GetObject returns the object attributes along with its Body, which must be
+	// closed by the caller, or ErrNotFound if there is no such object.
+	GetObject(key string) (*ObjectSpec, error)
+	// ListObjects returns attributes of the objects sorted by key.
+	ListObjects(opt *ListOptions) ([]*ObjectSpec, error)
+}
+
+// ListOptions specifies the objects to list.
+type ListOptions struct {
+	// Prefix limits the listing to keys beginning with the prefix.
+	Prefix string
+	// StartAfter limits the listing to keys following StartAfter.
+	StartAfter string
+	// Limit sets maximum amount of listed objects, zero means no limit.
+	Limit int
+}
+
+// Presigner is implemented by stores that are able to generate
+// time-limited URLs granting read access to objects.
+type Presigner interface {
+	PresignGetObject(key string, ttl time.Duration) (string, error)
+}
+
+// ErrNotFound is returned by the stores if the requested object does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// PutOptions specifies additional attributes of a stored object.
+type PutOptions struct {
+	ContentType     string            `json:"contentType,omitempty"`
+	ContentEncoding string            `json:"contentEncoding,omitempty"`
+	Meta            map[string]string `json:"meta,omitempty"`
+	// Expires is the time the object should be deleted after, zero means never.
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// ObjectURLer is implemented by stores that are able to address
+// stored objects by URL, e.g. file:///tmp/blobs/prod/01E5Z6...
+type ObjectURLer interface {
+	ObjectURL(key string) string
+}
+
+// ObjectSpec describes a stored object.
+type ObjectSpec struct {
+	Path            string
+	Key             string
+	Body            io.ReadCloser
+	ETag            string
+	Version         string
+	UpdatedAt       time.Time
+	Meta            map[string]string
+	Size            int64
+	ContentType     string
+	ContentEncoding string
+	Expires         time.Time
+}
+
+// S3Spec is an alias of ObjectSpec kept for compatibility.
+type S3Spec = ObjectSpec
+
+// Blob store URL schemes supported by NewBlobStore.
+const (
+	SchemeFile   = "file"
+	SchemeMemory = "mem"
+	SchemeS3     = "s3"
+)
+
+// NewBlobStore initializes a blob store according to the options. The backend is
+// chosen by BlobStoreURL scheme: file:// for a local directory, mem:// for an
+// in-memory store and s3:// (also http(s):// or no URL at all) for an S3 remote.
+func NewBlobStore(opt *HookOptions) (BlobStore, error) {
+	if len(opt.BlobStoreURL) == 0 {
+		return newS3RemoteFromOptions(opt, "")
+	}
+
+	storeURL, err := url.Parse(opt.BlobStoreURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse blob store URL: %w", err)
+	}
+
+	switch storeURL.Scheme {
+	case SchemeFile:
+		return NewFileStore(filepath.FromSlash(storeURL.Host + storeURL.Path))
+	case SchemeMemory:
+		return NewMemoryStore(storeURL.Host), nil
+	case SchemeS3:
+		return newS3RemoteFromOptions(opt, storeURL.Host)
+	case "http", "https":
+		return newS3RemoteFromOptions(opt, "")
+	default:
+		return nil, fmt.Errorf("unsupported blob store URL scheme: %s", storeURL.Scheme)
+	}
+}
+
+func newS3RemoteFromOptions(opt *HookOptions, bucket string) (BlobStore, error) {
+	if len(opt.BlobStoreBucket) > 0 {
+		bucket = opt.BlobStoreBucket
+	}
+
+	return NewS3Store(
+		opt.BlobStoreAccount,
+		opt.BlobStoreKey,
+		opt.BlobStoreEndpoint,
+		opt.BlobStoreRegion,
+		bucket,
+		&S3RemoteOptions{
+			MultipartThreshold: opt.MultipartThreshold,
+			MultipartPartSize:  opt.MultipartPartSize,
+			PathStyle:          opt.BlobStorePathStyle,
+			TagExpires:         opt.BlobExpiresTag,
+		},
+	)
+}
+
+// blobStoreScheme returns the scheme of the blob store URL, if any.
+func blobStoreScheme(storeURL string) string {
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return ""
+	}
+
+	return u.Scheme
+}
+
+// specFromOptions returns object spec initialized with the put options.
+func specFromOptions(key string, opt *PutOptions) *ObjectSpec {
+	spec := &ObjectSpec{
+		Key: key,
+	}
+
+	if opt != nil {
+		spec.ContentType = opt.ContentType
+		spec.ContentEncoding = opt.ContentEncoding
+		spec.Meta = opt.Meta
+		spec.Expires = opt.Expires
+	}
+
+	return spec
+}
@@ -0,0 +1,241 @@
+package blob
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileStore is a BlobStore that keeps objects in a local directory,
+// each object's attributes are stored alongside in a JSON file.
+type FileStore struct {
+	root string
+}
+
+// metaFileSuffix is appended to object file names to get their metadata file names.
+const metaFileSuffix = ".meta.json"
+
+// NewFileStore initializes a file store rooted at the directory, creating it if needed.
+func NewFileStore(root string) (*FileStore, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob store dir: %w", err)
+	}
+
+	s := &FileStore{
+		root: root,
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) CheckAccess(prefix string) error {
+	body := []byte(time.Now().UTC().String())
+	_, err := s.PutObject(filepath.Join(prefix, "_touch"), bytes.NewReader(body), nil)
+
+	return err
+}
+
+func (s *FileStore) PutObject(key string, r io.Reader, opt *PutOptions) (*ObjectSpec, error) {
+	path := s.objectPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	// write into a temporary file first, so readers never observe partial objects
+	f, err := ioutil.TempFile(filepath.Dir(path), ".put-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	size, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opt == nil {
+		opt = &PutOptions{}
+	}
+
+	if err := writeJSONFile(path+metaFileSuffix, opt); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return nil, err
+	}
+
+	spec := specFromOptions(key, opt)
+	spec.Path = path
+	spec.UpdatedAt = time.Now().UTC()
+	spec.Size = size
+
+	return spec, nil
+}
+
+func (s *FileStore) HeadObject(key string) (*ObjectSpec, error) {
+	path := s.objectPath(key)
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var opt PutOptions
+	if err := readJSONFile(path+metaFileSuffix, &opt); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	spec := specFromOptions(key, &opt)
+	spec.Path = path
+	spec.UpdatedAt = info.ModTime().UTC()
+	spec.Size = info.Size()
+
+	return spec, nil
+}
+
+func (s *FileStore) GetObject(key string) (*ObjectSpec, error) {
+	spec, err := s.HeadObject(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.Body, err = os.Open(spec.Path); os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+func (s *FileStore) ListObjects(opt *ListOptions) ([]*ObjectSpec, error) {
+	if opt == nil {
+		opt = &ListOptions{}
+	}
+
+	var keys []string
+
+	err := filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !s.isObjectFile(path) {
+			return err
+		}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, opt.Prefix) && key > opt.StartAfter {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+
+	if opt.Limit > 0 && len(keys) > opt.Limit {
+		keys = keys[:opt.Limit]
+	}
+
+	specs := make([]*ObjectSpec, 0, len(keys))
+
+	for _, key := range keys {
+		if spec, err := s.HeadObject(key); err == nil {
+			specs = append(specs, spec)
+		}
+	}
+
+	return specs, nil
+}
+
+// isObjectFile reports whether the file is an object rather than
+// its metadata or a temporary file of an object being written.
+func (s *FileStore) isObjectFile(path string) bool {
+	return !strings.HasSuffix(path, metaFileSuffix) && !strings.HasPrefix(filepath.Base(path), ".put-")
+}
+
+// DeleteExpired walks the store and deletes objects expired by the time.
+func (s *FileStore) DeleteExpired(now time.Time) (int, error) {
+	var n int
+
+	err := filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, metaFileSuffix) {
+			return err
+		}
+
+		var opt PutOptions
+		if err := readJSONFile(path, &opt); err != nil {
+			// skip files that are not readable or were not written by the store
+			return nil
+		}
+
+		if opt.Expires.IsZero() || opt.Expires.After(now) {
+			return nil
+		}
+
+		if err := os.Remove(strings.TrimSuffix(path, metaFileSuffix)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		n++
+
+		return os.Remove(path)
+	})
+
+	return n, err
+}
+
+// ObjectURL returns file:// URL of the object.
+func (s *FileStore) ObjectURL(key string) string {
+	return fmt.Sprintf("%s://%s", SchemeFile, filepath.ToSlash(s.objectPath(key)))
+}
+
+// Root returns the directory of the store.
+func (s *FileStore) Root() string {
+	return s.root
+}
+
+func (s *FileStore) objectPath(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(filepath.Clean("/"+key)))
+}
+
+func readJSONFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,215 @@
+package blob
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a BlobStore that keeps objects in memory, useful for tests.
+type MemoryStore struct {
+	name string
+
+	mux     sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+type memoryObject struct {
+	data      []byte
+	opt       PutOptions
+	updatedAt time.Time
+}
+
+//nolint:gochecknoglobals
+var memoryStores = struct {
+	sync.Mutex
+	stores map[string]*MemoryStore
+}{
+	stores: make(map[string]*MemoryStore),
+}
+
+// NewMemoryStore returns the in-memory store registered with the name,
+// creating it if needed. Named stores allow to access the same objects
+// that have been uploaded by a hook configured with mem://name URL.
+func NewMemoryStore(name string) *MemoryStore {
+	memoryStores.Lock()
+	defer memoryStores.Unlock()
+
+	if s, ok := memoryStores.stores[name]; ok {
+		return s
+	}
+
+	s := &MemoryStore{
+		name:    name,
+		objects: make(map[string]*memoryObject),
+	}
+	memoryStores.stores[name] = s
+
+	return s
+}
+
+func (s *MemoryStore) CheckAccess(prefix string) error {
+	return nil
+}
+
+func (s *MemoryStore) PutObject(key string, r io.Reader, opt *PutOptions) (*ObjectSpec, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &memoryObject{
+		data:      data,
+		updatedAt: time.Now().UTC(),
+	}
+
+	if opt != nil {
+		obj.opt = *opt
+		obj.opt.Meta = copyMeta(opt.Meta)
+	}
+
+	s.mux.Lock()
+	s.objects[key] = obj
+	s.mux.Unlock()
+
+	spec := specFromOptions(key, opt)
+	spec.UpdatedAt = obj.updatedAt
+	spec.Size = int64(len(data))
+
+	return spec, nil
+}
+
+func (s *MemoryStore) HeadObject(key string) (*ObjectSpec, error) {
+	s.mux.RLock()
+	obj, ok := s.objects[key]
+	s.mux.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return obj.spec(key), nil
+}
+
+func (s *MemoryStore) GetObject(key string) (*ObjectSpec, error) {
+	s.mux.RLock()
+	obj, ok := s.objects[key]
+	s.mux.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	spec := obj.spec(key)
+	spec.Body = ioutil.NopCloser(bytes.NewReader(obj.data))
+
+	return spec, nil
+}
+
+func (s *MemoryStore) ListObjects(opt *ListOptions) ([]*ObjectSpec, error) {
+	if opt == nil {
+		opt = &ListOptions{}
+	}
+
+	var specs []*ObjectSpec
+
+	for _, key := range s.Keys() {
+		if !strings.HasPrefix(key, opt.Prefix) || key <= opt.StartAfter {
+			continue
+		}
+
+		if opt.Limit > 0 && len(specs) >= opt.Limit {
+			break
+		}
+
+		if spec, err := s.HeadObject(key); err == nil {
+			specs = append(specs, spec)
+		}
+	}
+
+	return specs, nil
+}
+
+// ObjectURL returns mem://name/key URL of the object.
+func (s *MemoryStore) ObjectURL(key string) string {
+	return fmt.Sprintf("%s://%s/%s", SchemeMemory, s.name, key)
+}
+
+// Object returns the contents and attributes of the object stored with the key.
+func (s *MemoryStore) Object(key string) (data []byte, opt *PutOptions, ok bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, nil, false
+	}
+
+	opt = &PutOptions{
+		ContentType:     obj.opt.ContentType,
+		ContentEncoding: obj.opt.ContentEncoding,
+		Meta:            copyMeta(obj.opt.Meta),
+		Expires:         obj.opt.Expires,
+	}
+
+	return append([]byte(nil), obj.data...), opt, true
+}
+
+// DeleteExpired deletes objects expired by the time.
+func (s *MemoryStore) DeleteExpired(now time.Time) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var n int
+
+	for key, obj := range s.objects {
+		if !obj.opt.Expires.IsZero() && obj.opt.Expires.Before(now) {
+			delete(s.objects, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// Keys returns sorted keys of all objects in the store.
+func (s *MemoryStore) Keys() []string {
+	s.mux.RLock()
+	keys := make([]string, 0, len(s.objects))
+
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	s.mux.RUnlock()
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (obj *memoryObject) spec(key string) *ObjectSpec {
+	spec := specFromOptions(key, &obj.opt)
+	spec.Meta = copyMeta(obj.opt.Meta)
+	spec.UpdatedAt = obj.updatedAt
+	spec.Size = int64(len(obj.data))
+
+	return spec
+}
+
+func copyMeta(meta map[string]string) map[string]string {
+	if meta == nil {
+		return nil
+	}
+
+	m := make(map[string]string, len(meta))
+	for k, v := range meta {
+		m[k] = v
+	}
+
+	return m
+}
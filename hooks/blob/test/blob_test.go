@@ -1,11 +1,25 @@
 package blob
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	blobHook "github.com/hatchify/output/hooks/blob"
+	"github.com/hatchify/output/hooks/blob/s3test"
 
 	"github.com/hatchify/output"
 )
@@ -20,14 +34,1398 @@ esse cillum dolore eu fugiat nulla pariatur. Excepteur sint
 occaecat cupidatat non proident, sunt in culpa qui officia
 deserunt mollit anim id est laborum.`)
 
+	srv := s3test.NewServer("test-bucket")
+	defer srv.Close()
+
+	hook, err := blobHook.NewHook(s3Options(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	var buf bytes.Buffer
+
+	out := output.NewOutputter(&buf, new(output.JSONFormatter), hook)
+	out.WithField("blob", testBlob).Infoln("test is running, trying to submit blob")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := hook.Status(); status.State != blobHook.StateReady {
+		t.Fatalf("expected the hook to be ready, got %s: %v", status.State, status.Err)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := entry["blob"].(string)
+	if _, ok := blobHook.BlobTime(key); !ok || !strings.HasPrefix(key, "test/") {
+		t.Fatalf("blob field is not replaced with the blob key: %v", entry["blob"])
+	}
+
+	obj, ok := srv.Object("test-bucket", key)
+	if !ok {
+		t.Fatalf("blob %s is not uploaded, bucket has %v", key, srv.Keys("test-bucket"))
+	}
+
+	if !bytes.Equal(obj.Body, testBlob) {
+		t.Errorf("unexpected blob contents: %q", obj.Body)
+	} else if obj.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected content type: %s", obj.ContentType)
+	} else if obj.Meta["level"] != "info" || obj.Meta["env"] != "test" {
+		t.Errorf("unexpected blob metadata: %v", obj.Meta)
+	} else if len(obj.Tagging) > 0 {
+		t.Errorf("expected no object tags without BlobExpiresTag, got %s", obj.Tagging)
+	}
+
+	store, err := blobHook.NewBlobStore(s3Options(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := blobHook.ListBlobs(store, "test", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(blobs) != 1 || blobs[0].Key != key {
+		t.Fatalf("unexpected blobs listed: %v", blobs)
+	}
+
+	spec, err := store.GetObject(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := blobHook.DecodeBlob(spec, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(blob.Data, testBlob) {
+		t.Errorf("unexpected blob contents: %q", blob.Data)
+	}
+
+	if _, err := store.HeadObject("test/missing"); err != blobHook.ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	opts := s3Options(srv)
+	opts.BlobExpiresTag = true
+
+	taggingHook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taggingHook.Close()
+
+	buf.Reset()
+
+	out = output.NewOutputter(&buf, new(output.JSONFormatter), taggingHook)
+	out.WithField("blob", testBlob).Infoln("submitting tagged blob")
+
+	if err := taggingHook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	entry = nil
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ = entry["blob"].(string)
+	if obj, ok := srv.Object("test-bucket", key); !ok || !strings.HasPrefix(obj.Tagging, "output-expires=") {
+		t.Errorf("expected object tagged with its expiration time, got %+v", obj)
+	}
+}
+
+func TestBlobHookMultipart(t *testing.T) {
+	srv := s3test.NewServer("test-bucket")
+	defer srv.Close()
+
+	opts := s3Options(srv)
+	opts.MultipartThreshold = 1 << 20
+	opts.MultipartPartSize = blobHook.MinMultipartPartSize
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	var buf bytes.Buffer
+
+	dump := bytes.Repeat([]byte("large response body\n"), 600000)
+
+	out := output.NewOutputter(&buf, new(output.JSONFormatter), hook)
+	out.WithField("blob", bytes.NewReader(dump)).Infoln("submitting large blob")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := entry["blob"].(string)
+
+	obj, ok := srv.Object("test-bucket", key)
+	if !ok {
+		t.Fatalf("blob %s is not uploaded, bucket has %v", key, srv.Keys("test-bucket"))
+	}
+
+	if !bytes.Equal(obj.Body, dump) {
+		t.Errorf("unexpected blob contents of %d bytes", len(obj.Body))
+	} else if !strings.HasSuffix(obj.ETag, `-3"`) {
+		t.Errorf("expected the blob to be uploaded in 3 parts, got ETag %s", obj.ETag)
+	}
+
+	if srv.Uploads() != 0 {
+		t.Errorf("expected no incomplete multipart uploads, got %d", srv.Uploads())
+	}
+}
+
+func s3Options(srv *s3test.Server) *blobHook.HookOptions {
+	return &blobHook.HookOptions{
+		Env:                "test",
+		BlobStoreAccount:   "test",
+		BlobStoreKey:       "test",
+		BlobStoreEndpoint:  srv.URL,
+		BlobStoreRegion:    s3test.Region,
+		BlobStoreBucket:    "test-bucket",
+		BlobStorePathStyle: true,
+		SpoolDisabled:      true,
+	}
+}
+
+func TestBlobHookMemoryStore(t *testing.T) {
+	storeName := memStoreName(t)
+
+	opts := &blobHook.HookOptions{
+		Env:          "test",
+		BlobStoreURL: "mem://" + storeName,
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	var buf bytes.Buffer
+
+	out := output.NewOutputter(&buf, new(output.JSONFormatter), hook)
+	out.WithField("blob", "hello blob").Infoln("submitting blob")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	store := blobHook.NewMemoryStore(storeName)
+
+	keys := store.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 uploaded blob, got %d", len(keys))
+	}
+
+	data, _, _ := store.Object(keys[0])
+	if string(data) != "hello blob" {
+		t.Errorf("unexpected blob contents: %q", data)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte(store.ObjectURL(keys[0]))) {
+		t.Errorf("blob URL not found in the log entry: %s", buf.String())
+	}
+}
+
+// flakyStore fails the first uploads and passes the rest to the underlying store.
+type flakyStore struct {
+	blobHook.BlobStore
+
+	mux      sync.Mutex
+	failures int
+	puts     int
+}
+
+func (s *flakyStore) PutObject(key string, r io.Reader, opt *blobHook.PutOptions) (*blobHook.ObjectSpec, error) {
+	s.mux.Lock()
+	if s.failures > 0 {
+		s.failures--
+		s.mux.Unlock()
+
+		return nil, errors.New("store is unavailable")
+	}
+	s.puts++
+	s.mux.Unlock()
+
+	return s.BlobStore.PutObject(key, r, opt)
+}
+
+func TestBlobHookSpool(t *testing.T) {
+	spoolDir, err := ioutil.TempDir("", "blob-spool-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spoolDir)
+
+	store := blobHook.NewMemoryStore(memStoreName(t))
 	opts := &blobHook.HookOptions{
 		Env: "test",
+		BlobStore: &flakyStore{
+			BlobStore: store,
+			failures:  2,
+		},
+		SpoolDir:      spoolDir,
+		SpoolRetryMin: 10 * time.Millisecond,
+		SpoolRetryMax: 20 * time.Millisecond,
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	out := output.NewOutputter(ioutil.Discard, nil, hook)
+	out.WithField("blob", "spooled blob").Warningln("submitting blob")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := hook.Stats(); stats.Spool.Files != 1 || stats.Spool.Spooled != 1 {
+		t.Fatalf("expected blob to be spooled, got %+v", stats.Spool)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for hook.Stats().Spool.Files > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("spooled blob has not been uploaded: %+v", hook.Stats().Spool)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if keys := store.Keys(); len(keys) != 1 {
+		t.Fatalf("expected 1 uploaded blob, got %d", len(keys))
+	}
+
+	if stats := hook.Stats(); stats.Spool.Uploaded != 1 || stats.Spool.Bytes != 0 {
+		t.Errorf("unexpected spool stats: %+v", stats.Spool)
+	}
+}
+
+func TestBlobHookSharedSpool(t *testing.T) {
+	spoolDir, err := ioutil.TempDir("", "blob-spool-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spoolDir)
+
+	store := blobHook.NewMemoryStore(memStoreName(t))
+
+	// the blob is spooled by a process that can't upload it, and uploaded by another one
+	failing, err := blobHook.NewHook(&blobHook.HookOptions{
+		Env: "test",
+		BlobStore: &flakyStore{
+			BlobStore: store,
+			failures:  1 << 20,
+		},
+		SpoolDir:      spoolDir,
+		SpoolRetryMin: 10 * time.Millisecond,
+		SpoolRetryMax: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer failing.Close()
+
+	out := output.NewOutputter(ioutil.Discard, nil, failing)
+	out.WithField("blob", "spooled blob").Warningln("submitting blob")
+
+	if err := failing.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := failing.Stats(); stats.Spool.Files != 1 {
+		t.Fatalf("expected blob to be spooled, got %+v", stats.Spool)
+	}
+
+	uploading, err := blobHook.NewHook(&blobHook.HookOptions{
+		Env:           "test",
+		BlobStore:     store,
+		SpoolDir:      spoolDir,
+		SpoolRetryMin: 10 * time.Millisecond,
+		SpoolRetryMax: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uploading.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(store.Keys()) == 0 || failing.Stats().Spool.Files > 0 || uploading.Stats().Spool.Files > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("spooled blob has not been uploaded: %+v, %+v", failing.Stats().Spool, uploading.Stats().Spool)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, hook := range []blobHook.Hook{failing, uploading} {
+		if stats := hook.Stats(); stats.Spool.Bytes != 0 {
+			t.Errorf("unexpected spool stats: %+v", stats.Spool)
+		}
+	}
+}
+
+func TestBlobHookSpoolQuarantine(t *testing.T) {
+	spoolDir, err := ioutil.TempDir("", "blob-spool-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spoolDir)
+
+	id := blobHook.NewBlobID()
+	files := map[string]string{
+		id + ".json": `{"key": "test/`,
+		id + ".blob": "corrupted record blob",
+		// a record being written by another process
+		blobHook.NewBlobID() + ".json.123.tmp": `{"key": "test/`,
+	}
+
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(spoolDir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := blobHook.NewMemoryStore(memStoreName(t))
+	opts := &blobHook.HookOptions{
+		Env:           "test",
+		BlobStore:     store,
+		SpoolDir:      spoolDir,
+		SpoolRetryMin: 10 * time.Millisecond,
+		SpoolRetryMax: 20 * time.Millisecond,
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for hook.Stats().Spool.Files > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("corrupted record has not been quarantined: %+v", hook.Stats().Spool)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, name := range []string{id + ".json", id + ".blob"} {
+		if _, err := os.Stat(filepath.Join(spoolDir, "quarantine", name)); err != nil {
+			t.Errorf("expected %s to be quarantined: %v", name, err)
+		}
+	}
+
+	for name := range files {
+		if strings.HasSuffix(name, ".tmp") {
+			if _, err := os.Stat(filepath.Join(spoolDir, name)); err != nil {
+				t.Errorf("expected temp file to be left for its writer: %v", err)
+			}
+		}
+	}
+
+	if keys := store.Keys(); len(keys) != 0 {
+		t.Errorf("expected nothing uploaded, got %v", keys)
+	}
+}
+
+func TestBlobHookCompression(t *testing.T) {
+	storeName := memStoreName(t)
+
+	opts := &blobHook.HookOptions{
+		Env:          "test",
+		BlobStoreURL: "mem://" + storeName,
+		Compression:  blobHook.CompressionGzip,
+		AppVersion:   "1.2.3",
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	testBlob := `{"request": {"method": "GET", "path": "/"}}`
+
+	out := output.NewOutputter(ioutil.Discard, nil, hook)
+	out.WithField("blob", testBlob).Errorln("request failed")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	store := blobHook.NewMemoryStore(storeName)
+
+	keys := store.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 uploaded blob, got %d", len(keys))
+	}
+
+	data, opt, _ := store.Object(keys[0])
+	if opt.ContentType != "application/json" || opt.ContentEncoding != "gzip" {
+		t.Errorf("unexpected content type %q and encoding %q", opt.ContentType, opt.ContentEncoding)
+	}
+
+	if opt.Meta["level"] != "error" || opt.Meta["message"] != "request failed" || opt.Meta["app-version"] != "1.2.3" {
+		t.Errorf("unexpected blob metadata: %v", opt.Meta)
+	}
+
+	r, err := blobHook.NewDecompressingReader(bytes.NewReader(data), opt.ContentEncoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ = ioutil.ReadAll(r); string(data) != testBlob {
+		t.Errorf("unexpected blob contents: %q", data)
+	}
+}
+
+func TestBlobHookEncryption(t *testing.T) {
+	storeName := memStoreName(t)
+
+	key := bytes.Repeat([]byte{0x42}, 32)
+	opts := &blobHook.HookOptions{
+		Env:             "test",
+		BlobStoreURL:    "mem://" + storeName,
+		Compression:     blobHook.CompressionZstd,
+		EncryptionKey:   key,
+		EncryptionKeyID: "test-key",
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	testBlob := "<html><body>customer PII</body></html>"
+
+	out := output.NewOutputter(ioutil.Discard, nil, hook)
+	out.WithField("blob", testBlob).Errorln("request failed for customer 42")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	store := blobHook.NewMemoryStore(storeName)
+
+	keys := store.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 uploaded blob, got %d", len(keys))
+	}
+
+	data, opt, _ := store.Object(keys[0])
+	if bytes.Contains(data, []byte("PII")) || !blobHook.IsEncrypted(opt.Meta) {
+		t.Fatal("blob is not encrypted")
+	}
+
+	for name, value := range opt.Meta {
+		switch name {
+		case "message", "field", "env", "level":
+			t.Errorf("unexpected plaintext metadata of encrypted blob: %s=%q", name, value)
+		}
+
+		if strings.Contains(value, "customer") {
+			t.Errorf("entry message leaked into metadata: %s=%q", name, value)
+		}
+	}
+
+	if _, err := blobHook.NewDecryptingReader(bytes.NewReader(data), keys[0], opt.Meta, blobHook.Keyring{}); err == nil {
+		t.Error("expected blob decryption to fail without the key")
+	}
+
+	if _, err := blobHook.NewDecryptingReader(bytes.NewReader(data), "test/"+blobHook.NewBlobID(), opt.Meta, blobHook.Keyring{
+		"test-key": key,
+	}); err == nil {
+		t.Error("expected blob decryption to fail under another object key")
+	}
+
+	// the format without the object key authenticated is not accepted
+	legacyMeta := make(map[string]string, len(opt.Meta))
+	for k, v := range opt.Meta {
+		if strings.EqualFold(k, "enc-alg") {
+			v = "AES-GCM-ENVELOPE"
+		}
+
+		legacyMeta[k] = v
+	}
+
+	if _, err := blobHook.NewDecryptingReader(bytes.NewReader(data), keys[0], legacyMeta, blobHook.Keyring{
+		"test-key": key,
+	}); err == nil {
+		t.Error("expected blob decryption to fail with the legacy algorithm")
+	}
+
+	r, err := blobHook.NewDecryptingReader(bytes.NewReader(data), keys[0], opt.Meta, blobHook.Keyring{
+		"test-key": key,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contentType, contentEncoding := blobHook.PlaintextAttributes(opt.Meta)
+	if contentType != "text/html; charset=utf-8" || contentEncoding != "zstd" {
+		t.Errorf("unexpected content type %q and encoding %q", contentType, contentEncoding)
+	}
+
+	rc, err := blobHook.NewDecompressingReader(r, contentEncoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	if data, _ = ioutil.ReadAll(rc); string(data) != testBlob {
+		t.Errorf("unexpected blob contents: %q", data)
+	}
+
+	spec, err := store.GetObject(keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := blobHook.DecodeBlob(spec, blobHook.Keyring{"test-key": key})
+	if err != nil {
+		t.Fatal(err)
+	} else if string(blob.Data) != testBlob || blob.ContentType != contentType {
+		t.Errorf("unexpected decoded blob: %q (%s)", blob.Data, blob.ContentType)
+	}
+}
+
+func TestNewBlobIDConcurrent(t *testing.T) {
+	const workers, count = 4, 1000
+
+	ids := make(chan string, workers*count)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < count; j++ {
+				ids <- blobHook.NewBlobID()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool, workers*count)
+
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate blob ID %s", id)
+		}
+
+		seen[id] = true
+	}
+}
+
+func TestBlobKey(t *testing.T) {
+	const id = "01E5Z6B2Z7KJ8V5Q6M3R9X0T1A"
+
+	for _, ref := range []string{
+		id,
+		"prod/" + id,
+		"https://logs.example.com/prod/" + id,
+		"https://blobs.example.com/" + id,
+		"https://bucket.s3.amazonaws.com/prod/" + id + "?X-Amz-Expires=3600",
+		"file:///var/log/blobs/prod/" + id,
+		"mem://store/prod/" + id,
+	} {
+		if key := blobHook.BlobKey("prod", ref); key != "prod/"+id {
+			t.Errorf("unexpected key of %q: %s", ref, key)
+		}
+	}
+
+	// the env of the URL takes precedence over the env of the caller
+	for ref, expected := range map[string]string{
+		"https://bucket.s3.amazonaws.com/prod/" + id + "?X-Amz-Expires=3600": "prod/" + id,
+		"https://logs.example.com/prod/" + id:                                "prod/" + id,
+		"https://blobs.example.com/" + id + "?v=1":                           "local/" + id,
+		"prod/" + id: "prod/" + id,
+	} {
+		if key := blobHook.BlobKey("local", ref); key != expected {
+			t.Errorf("unexpected key of %q: %s, expected %s", ref, key, expected)
+		}
+	}
+}
+
+func TestBlobHookRetention(t *testing.T) {
+	storeName := memStoreName(t)
+
+	opts := &blobHook.HookOptions{
+		Env:              "test",
+		BlobStoreURL:     "mem://" + storeName,
+		BlobRetentionTTL: time.Hour,
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	out := output.NewOutputter(ioutil.Discard, nil, hook)
+	out.WithField("blob", "expiring blob").Infoln("submitting blob")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	store := blobHook.NewMemoryStore(storeName)
+
+	keys := store.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 uploaded blob, got %d", len(keys))
+	}
+
+	_, opt, _ := store.Object(keys[0])
+	if ttl := time.Until(opt.Expires); ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("unexpected blob expiration time: %s", opt.Expires)
+	}
+
+	if n, _ := store.DeleteExpired(time.Now()); n != 0 {
+		t.Errorf("expected no blobs to be expired, got %d", n)
+	}
+
+	if n, _ := store.DeleteExpired(time.Now().Add(2 * time.Hour)); n != 1 {
+		t.Errorf("expected 1 blob to be expired, got %d", n)
+	}
+
+	if keys := store.Keys(); len(keys) != 0 {
+		t.Errorf("expected expired blob to be deleted, got %v", keys)
+	}
+}
+
+func TestBlobHookFields(t *testing.T) {
+	storeName := memStoreName(t)
+
+	opts := &blobHook.HookOptions{
+		Env:          "test",
+		BlobStoreURL: "mem://" + storeName,
+		BlobFields:   []string{"request_blob", "response_blob"},
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer hook.Close()
+
+	type response struct {
+		Status int    `json:"status"`
+		Body   string `json:"body"`
+	}
+
+	var buf bytes.Buffer
+
+	out := output.NewOutputter(&buf, new(output.JSONFormatter), hook).WithFields(output.Fields{
+		"request_blob":  strings.NewReader("GET / HTTP/1.1"),
+		"response_blob": response{Status: 200, Body: "OK"},
+	})
+	out.Infoln("request done")
+	out.Infoln("request done again")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	store := blobHook.NewMemoryStore(storeName)
+
+	keys := store.Keys()
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 uploaded blobs, got %d", len(keys))
+	}
+
+	blobs := make(map[string]string)
+
+	for _, key := range keys {
+		data, opt, _ := store.Object(key)
+		blobs[opt.Meta["field"]] += string(data)
+	}
+
+	if blobs["request_blob"] != "GET / HTTP/1.1" {
+		t.Errorf("unexpected request blob: %q", blobs["request_blob"])
+	}
+
+	// the struct is uploaded for both entries, while the reader is drained by the first one
+	if blobs["response_blob"] != `{"status":200,"body":"OK"}{"status":200,"body":"OK"}` {
+		t.Errorf("unexpected response blob: %q", blobs["response_blob"])
+	}
+
+	var entry map[string]interface{}
+	if err := json.NewDecoder(&buf).Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range opts.BlobFields {
+		if blobURL, _ := entry[field].(string); !strings.HasPrefix(blobURL, "mem://"+storeName+"/test/") {
+			t.Errorf("field %s is not replaced with blob URL: %v", field, entry[field])
+		}
+	}
+}
+
+func TestBlobHookOffload(t *testing.T) {
+	storeName := memStoreName(t)
+
+	opts := &blobHook.HookOptions{
+		Env:              "test",
+		BlobStoreURL:     "mem://" + storeName,
+		OffloadThreshold: 16,
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	var buf bytes.Buffer
+
+	largeValue := strings.Repeat("large value ", 10)
+
+	out := output.NewOutputter(&buf, new(output.JSONFormatter), hook)
+	out.WithFields(output.Fields{
+		"body":   largeValue,
+		"module": "payments",
+	}).Infoln("request done")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	store := blobHook.NewMemoryStore(storeName)
+
+	keys := store.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 uploaded blob, got %d", len(keys))
+	}
+
+	if data, _, _ := store.Object(keys[0]); string(data) != largeValue {
+		t.Errorf("unexpected blob contents: %q", data)
+	}
+
+	var entry map[string]interface{}
+	if err := json.NewDecoder(&buf).Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry["body"] != store.ObjectURL(keys[0]) || entry["body_size"] != float64(len(largeValue)) {
+		t.Errorf("oversized field is not offloaded: %v", entry)
+	}
+
+	if entry["module"] != "payments" {
+		t.Errorf("unexpected module field: %v", entry["module"])
+	}
+}
+
+func TestBlobHookDedup(t *testing.T) {
+	store := &flakyStore{
+		BlobStore: blobHook.NewMemoryStore(memStoreName(t)),
+	}
+	opts := &blobHook.HookOptions{
+		Env:       "test",
+		BlobStore: store,
+		KeyMode:   blobHook.KeyModeContentHash,
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	var buf bytes.Buffer
+
+	out := output.NewOutputter(&buf, new(output.JSONFormatter), hook)
+
+	for i := 0; i < 3; i++ {
+		out.WithField("blob", "retried request body").Warningln("request failed")
+
+		if err := hook.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out.WithField("blob", "another request body").Warningln("request failed")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if store.puts != 2 {
+		t.Errorf("expected 2 blobs to be uploaded, got %d", store.puts)
+	}
+
+	const hash = "46e472d6f365952e48f9530ce7a041ba5eea5357c1ecb9729d397bc5a6bbf41b"
+
+	if _, err := store.HeadObject("test/" + hash); err != nil {
+		t.Errorf("content-addressed blob is not found: %v", err)
+	}
+
+	dec := json.NewDecoder(&buf)
+
+	for i := 0; i < 3; i++ {
+		var entry map[string]interface{}
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if entry["blob"] != "test/"+hash {
+			t.Errorf("unexpected blob URL: %v", entry["blob"])
+		}
+	}
+}
+
+// inaccessibleStore fails access checks.
+type inaccessibleStore struct {
+	blobHook.BlobStore
+}
+
+func (s *inaccessibleStore) CheckAccess(prefix string) error {
+	return errors.New("access denied")
+}
+
+func TestBlobHookDegraded(t *testing.T) {
+	opts := &blobHook.HookOptions{
+		Env: "test",
+		BlobStore: &inaccessibleStore{
+			BlobStore: blobHook.NewMemoryStore(memStoreName(t)),
+		},
+		SpoolDisabled: true,
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for hook.Status().State != blobHook.StateDegraded {
+		if time.Now().After(deadline) {
+			t.Fatalf("hook is not degraded: %+v", hook.Status())
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := hook.Status().Err; err == nil || err.Error() != "access denied" {
+		t.Errorf("unexpected status error: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	out := output.NewOutputter(&buf, new(output.JSONFormatter), hook)
+	out.WithField("blob", "discarded blob").Infoln("submitting blob")
+
+	var entry map[string]interface{}
+	if err := json.NewDecoder(&buf).Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := entry["blob"]; ok {
+		t.Errorf("expected blob to be discarded, got %v", entry["blob"])
+	}
+}
+
+//nolint:gochecknoglobals
+var memStoreSeq uint64
+
+// memStoreName returns a name of a new memory store, memory stores are
+// registered by name for the process, so tests running again get new stores.
+func memStoreName(t *testing.T) string {
+	return fmt.Sprintf("%s-%d", t.Name(), atomic.AddUint64(&memStoreSeq, 1))
+}
+
+// blockingStore holds uploads until released.
+type blockingStore struct {
+	blobHook.BlobStore
+
+	started chan string
+	release chan struct{}
+}
+
+func newBlockingStore(store blobHook.BlobStore) *blockingStore {
+	return &blockingStore{
+		BlobStore: store,
+		started:   make(chan string, 16),
+		release:   make(chan struct{}),
+	}
+}
+
+func (s *blockingStore) PutObject(key string, r io.Reader, opt *blobHook.PutOptions) (*blobHook.ObjectSpec, error) {
+	s.started <- key
+	<-s.release
+
+	return s.BlobStore.PutObject(key, r, opt)
+}
+
+func TestBlobHookQueue(t *testing.T) {
+	for _, policy := range []blobHook.QueuePolicy{blobHook.QueuePolicyDrop, blobHook.QueuePolicyBlock} {
+		memStore := blobHook.NewMemoryStore(memStoreName(t))
+		store := newBlockingStore(memStore)
+		opts := &blobHook.HookOptions{
+			Env:               "test",
+			BlobStore:         store,
+			UploadConcurrency: 1,
+			UploadQueueSize:   1,
+			UploadQueuePolicy: policy,
+			SpoolDisabled:     true,
+		}
+
+		hook, err := blobHook.NewHook(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := output.NewOutputter(ioutil.Discard, nil, hook)
+
+		// the worker takes the first blob and waits for the store
+		out.WithField("blob", "first").Infoln("submitting blob")
+		<-store.started
+
+		// the second blob fills the queue
+		out.WithField("blob", "second").Infoln("submitting blob")
+
+		logged := make(chan struct{})
+		go func() {
+			out.WithField("blob", "third").Infoln("submitting blob")
+			close(logged)
+		}()
+
+		select {
+		case <-logged:
+			if policy == blobHook.QueuePolicyBlock {
+				t.Fatal("expected logging to block while the upload queue is full")
+			}
+		case <-time.After(100 * time.Millisecond):
+			if policy == blobHook.QueuePolicyDrop {
+				t.Fatal("expected the blob to be dropped while the upload queue is full")
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		if err := hook.Flush(ctx); err != context.DeadlineExceeded {
+			t.Errorf("expected flush to time out while uploads are held, got %v", err)
+		}
+		cancel()
+
+		close(store.release)
+		<-logged
+
+		if err := hook.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		expectedDropped, expectedKeys := uint64(0), 3
+		if policy == blobHook.QueuePolicyDrop {
+			expectedDropped, expectedKeys = 1, 2
+		}
+
+		if dropped := hook.Stats().Dropped; dropped != expectedDropped {
+			t.Errorf("expected %d dropped blobs, got %d", expectedDropped, dropped)
+		}
+
+		if keys := memStore.Keys(); len(keys) != expectedKeys {
+			t.Errorf("expected %d uploaded blobs, got %d", expectedKeys, len(keys))
+		}
+
+		hook.Close()
+	}
+}
+
+func TestBlobHookRetrieval(t *testing.T) {
+	storeDir, err := ioutil.TempDir("", "blob-store-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storeDir)
+
+	opts := &blobHook.HookOptions{
+		Env:          "test",
+		BlobStoreURL: "file://" + storeDir,
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
 
-	hook, _ := blobHook.NewHook(opts)
-	out := output.NewOutputter(os.Stderr, new(output.TextFormatter), hook)
 	ts := time.Now()
 
-	out.WithField("blob", testBlob).Infoln("test is running, trying to submit blob")
-	out.Debug("test done in %s", time.Since(ts))
+	out := output.NewOutputter(ioutil.Discard, nil, hook)
+	out.WithField("blob", "first blob").Infoln("submitting blob")
+	time.Sleep(5 * time.Millisecond)
+	out.WithField("blob", "second blob").Infoln("submitting blob")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := blobHook.NewFileStore(storeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := blobHook.ListBlobs(store, "test", ts.Add(-time.Second), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(blobs) != 2 {
+		t.Fatalf("expected 2 blobs, got %d", len(blobs))
+	}
+
+	spec, err := store.GetObject(blobs[1].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spec.Body.Close()
+
+	if data, _ := ioutil.ReadAll(spec.Body); string(data) != "second blob" {
+		t.Errorf("unexpected blob contents: %q", data)
+	}
+
+	firstBlobTime, _ := blobHook.BlobTime(blobs[0].Key)
+
+	blobs, err = blobHook.ListBlobs(store, "test", time.Time{}, firstBlobTime)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(blobs) != 1 {
+		t.Fatalf("expected 1 blob, got %d", len(blobs))
+	}
+
+	if _, err := store.GetObject("test/missing"); err != blobHook.ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestBlobHookStreaming(t *testing.T) {
+	storeName := memStoreName(t)
+
+	store := blobHook.NewMemoryStore(storeName)
+	opts := &blobHook.HookOptions{
+		Env:                "test",
+		BlobStore:          store,
+		Compression:        blobHook.CompressionGzip,
+		MultipartThreshold: 1024,
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	var buf bytes.Buffer
+
+	dump := strings.Repeat("large response body\n", 1024)
+
+	out := output.NewOutputter(&buf, new(output.JSONFormatter), hook)
+	out.WithField("blob", strings.NewReader(dump)).Infoln("submitting large blob")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	url, _ := entry["blob"].(string)
+	key := strings.TrimPrefix(url, "mem://"+storeName+"/")
+
+	data, putOpts, ok := store.Object(key)
+	if !ok {
+		t.Fatalf("blob is not found by URL %q", url)
+	} else if putOpts.ContentEncoding != blobHook.CompressionGzip {
+		t.Fatalf("expected gzip content encoding, got %q", putOpts.ContentEncoding)
+	}
+
+	r, err := blobHook.NewDecompressingReader(bytes.NewReader(data), putOpts.ContentEncoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plain, _ := ioutil.ReadAll(r); string(plain) != dump {
+		t.Errorf("unexpected blob contents of %d bytes", len(plain))
+	}
+}
+
+func TestBlobViewer(t *testing.T) {
+	store := blobHook.NewMemoryStore(memStoreName(t))
+
+	srv := httptest.NewServer(blobHook.NewViewerHandler(store, &blobHook.ViewerOptions{
+		Env: "test",
+	}))
+	defer srv.Close()
+
+	hook, err := blobHook.NewHook(&blobHook.HookOptions{
+		Env:           "test",
+		BlobStore:     store,
+		BlobViewerURL: srv.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	var buf bytes.Buffer
+
+	out := output.NewOutputter(&buf, new(output.JSONFormatter), hook)
+	out.WithField("blob", `{"status":"failed","code":42}`).Warningln("request failed")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	blobURL, _ := entry["blob"].(string)
+	if !strings.HasPrefix(blobURL, srv.URL+"/test/") {
+		t.Fatalf("blob field is not a viewer URL: %s", blobURL)
+	}
+
+	get := func(url string) (*http.Response, string) {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		return resp, string(body)
+	}
+
+	if resp, body := get(blobURL); resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status of the blob page: %s", resp.Status)
+	} else if !strings.Contains(body, `<span class="key">&#34;status&#34;</span>: <span class="str">&#34;failed&#34;</span>`) {
+		t.Errorf("blob is not highlighted: %s", body)
+	}
+
+	if resp, body := get(blobURL + "?raw"); body != `{"status":"failed","code":42}` {
+		t.Errorf("unexpected raw blob: %s", body)
+	} else if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected raw blob content type: %s", resp.Header.Get("Content-Type"))
+	}
+
+	if _, body := get(srv.URL); !strings.Contains(body, strings.TrimPrefix(blobURL, srv.URL+"/")) {
+		t.Errorf("blob is not listed on the index page: %s", body)
+	}
+
+	if resp, _ := get(srv.URL + "/test/missing"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing blob, got %s", resp.Status)
+	}
+}
+
+func TestBlobHookRules(t *testing.T) {
+	store := blobHook.NewMemoryStore(memStoreName(t))
+	opts := &blobHook.HookOptions{
+		Env:       "test",
+		BlobStore: store,
+		Rules: []blobHook.Rule{
+			{Env: "prod"},
+			{Env: "test", Levels: blobHook.AtLeast(output.WarnLevel)},
+			{Fields: map[string]string{"module": "payments"}},
+		},
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	var buf bytes.Buffer
+
+	out := output.NewOutputter(&buf, new(output.JSONFormatter), hook)
+	out.WithField("blob", "verbose dump").Infoln("request done")
+	out.WithField("blob", "failure dump").Warningln("request failed")
+	out.WithFields(output.Fields{
+		"blob":   "payment dump",
+		"module": "payments",
+	}).Infoln("payment done")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := store.Keys(); len(keys) != 2 {
+		t.Errorf("expected 2 blobs to be uploaded, got %d", len(keys))
+	}
+
+	dec := json.NewDecoder(&buf)
+
+	for _, uploaded := range []bool{false, true, true} {
+		var entry map[string]interface{}
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := entry["blob"]; ok != uploaded {
+			t.Errorf("unexpected blob field of %q entry: %v", entry["msg"], entry["blob"])
+		}
+	}
+
+	rules, err := blobHook.ParseRules("env=staging; env=prod level=error; level=info module=payments sample=0.1")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rules) != 3 {
+		t.Fatalf("expected 3 rules, got %d", len(rules))
+	} else if rules[1].Env != "prod" || len(rules[1].Levels) != 3 {
+		t.Errorf("unexpected rule: %+v", rules[1])
+	} else if rules[2].Fields["module"] != "payments" || rules[2].SampleRate != 0.1 {
+		t.Errorf("unexpected rule: %+v", rules[2])
+	}
+
+	for _, text := range []string{"level=loud", "env=prod sample=0", "sample=1.5", "sample=-0.1", "sample=NaN"} {
+		if _, err := blobHook.ParseRules(text); err == nil {
+			t.Errorf("expected %q to fail", text)
+		}
+	}
+}
+
+func TestBlobHookLocal(t *testing.T) {
+	localDir, err := ioutil.TempDir("", "blob-local-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(localDir)
+
+	opts := &blobHook.HookOptions{
+		Env:      "local",
+		LocalDir: localDir,
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	var buf bytes.Buffer
+
+	out := output.NewOutputter(&buf, new(output.JSONFormatter), hook)
+	out.WithField("blob", "local request dump").Infoln("request done")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	blobURL, _ := entry["blob"].(string)
+	if !strings.HasPrefix(blobURL, "file://"+filepath.ToSlash(localDir)+"/local/") {
+		t.Fatalf("blob field is not a local file URL: %s", blobURL)
+	}
+
+	data, err := ioutil.ReadFile(filepath.FromSlash(strings.TrimPrefix(blobURL, "file://")))
+	if err != nil {
+		t.Fatal(err)
+	} else if string(data) != "local request dump" {
+		t.Errorf("unexpected blob contents: %q", data)
+	}
+}
+
+func TestBlobHookIndex(t *testing.T) {
+	store := blobHook.NewMemoryStore(memStoreName(t))
+	opts := &blobHook.HookOptions{
+		Env:          "test",
+		BlobStore:    store,
+		Compression:  blobHook.CompressionGzip,
+		IndexEnabled: true,
+		IndexFields:  []string{"request_id"},
+	}
+
+	hook, err := blobHook.NewHook(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	ts := time.Now()
+
+	out := output.NewOutputter(ioutil.Discard, nil, hook)
+
+	for _, requestID := range []string{"req-1", "req-2", "req-3"} {
+		out.WithFields(output.Fields{
+			"blob":       "response of " + requestID,
+			"request_id": requestID,
+		}).Warningln("request failed")
+	}
+
+	out.WithField("blob", "unrelated").Infoln("request done")
+
+	if err := hook.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := blobHook.SearchIndex(store, "test", &blobHook.IndexQuery{
+		From:   ts.Add(-time.Second),
+		Fields: map[string]string{"request_id": "req-2"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != 1 {
+		t.Fatalf("expected 1 index record, got %d", len(records))
+	}
+
+	if rec := records[0]; rec.Level != "warning" || rec.Message != "request failed" || rec.Field != "blob" {
+		t.Errorf("unexpected index record: %+v", rec)
+	}
+
+	spec, err := store.GetObject(records[0].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blob, err := blobHook.DecodeBlob(spec, nil); err != nil {
+		t.Fatal(err)
+	} else if string(blob.Data) != "response of req-2" {
+		t.Errorf("unexpected blob contents: %q", blob.Data)
+	}
+
+	records, err = blobHook.SearchIndex(store, "test", &blobHook.IndexQuery{
+		Level: "warning",
+		Limit: 2,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != 2 || records[0].Fields["request_id"] != "req-1" {
+		t.Errorf("unexpected index records: %+v", records)
+	}
+
+	blobs, err := blobHook.ListBlobs(store, "test", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(blobs) != 4 {
+		t.Errorf("expected index parts not to be listed as blobs, got %d blobs", len(blobs))
+	}
 }
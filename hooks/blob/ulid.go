@@ -1,6 +1,7 @@
 package blob
 
 import (
+	"io"
 	"math/rand"
 	"sync"
 	"time"
@@ -12,7 +13,7 @@ import (
 // Universally Unique Lexicographically Sortable Identifier
 // - see https://github.com/ulid/spec
 func NewBlobID() string {
-	return ulid.MustNew(ulid.Timestamp(time.Now()), globalRand).String()
+	return ulid.MustNew(ulid.Timestamp(time.Now()), globalEntropy).String()
 }
 
 //nolint:gochecknoglobals
@@ -20,6 +21,28 @@ var globalRand = rand.New(&lockedSource{
 	src: rand.NewSource(time.Now().UnixNano()),
 })
 
+// globalEntropy reads globalRand under a mutex, as rand.Rand.Read keeps its own state
+// that lockedSource doesn't protect, and blob IDs are generated concurrently.
+//
+//nolint:gochecknoglobals
+var globalEntropy = &lockedReader{
+	r: globalRand,
+}
+
+// lockedReader provides io.Reader with a mutex to avoid races.
+type lockedReader struct {
+	lk sync.Mutex
+	r  io.Reader
+}
+
+func (r *lockedReader) Read(p []byte) (n int, err error) {
+	r.lk.Lock()
+	n, err = r.r.Read(p)
+	r.lk.Unlock()
+
+	return
+}
+
 // lockedSource provides rand.Source with a mutex to avoid races.
 type lockedSource struct {
 	lk  sync.Mutex
@@ -0,0 +1,191 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// QueuePolicy specifies how the hook behaves when the upload queue is full.
+type QueuePolicy int
+
+const (
+	// QueuePolicyBlock makes the logging goroutine wait until there is
+	// a free slot in the upload queue.
+	QueuePolicyBlock QueuePolicy = iota
+	// QueuePolicyDrop discards the blob if the upload queue is full.
+	QueuePolicyDrop
+)
+
+// ParseQueuePolicy takes a string policy name and returns the QueuePolicy constant.
+func ParseQueuePolicy(name string) (QueuePolicy, bool) {
+	switch strings.ToLower(name) {
+	case "block":
+		return QueuePolicyBlock, true
+	case "drop":
+		return QueuePolicyDrop, true
+	}
+
+	return QueuePolicyBlock, false
+}
+
+var (
+	errUploaderClosed = errors.New("blob uploader is closed")
+	errQueueFull      = errors.New("blob upload queue is full")
+)
+
+type uploadJob struct {
+	key     string
+	payload []byte
+	opt     *PutOptions
+
+	// body is the rest of a large blob streamed to the store,
+	// the payload holds the beginning of the blob in this case.
+	body io.Reader
+
+	// dedup is set for content-addressed blobs, which are not
+	// uploaded again if already present in the store.
+	dedup bool
+}
+
+// uploader runs a pool of workers that consume upload jobs from a bounded queue.
+type uploader struct {
+	queue  chan *uploadJob
+	policy QueuePolicy
+	upload func(job *uploadJob)
+
+	// queueMux guards queue against sends after it has been closed.
+	queueMux sync.RWMutex
+	closed   bool
+	workers  sync.WaitGroup
+
+	pendingMux sync.Mutex
+	pending    int
+	idle       []chan struct{}
+
+	dropped uint64
+}
+
+func newUploader(concurrency, queueSize int, policy QueuePolicy, upload func(job *uploadJob)) *uploader {
+	u := &uploader{
+		queue:  make(chan *uploadJob, queueSize),
+		policy: policy,
+		upload: upload,
+	}
+
+	u.workers.Add(concurrency)
+
+	for i := 0; i < concurrency; i++ {
+		go u.work()
+	}
+
+	return u
+}
+
+func (u *uploader) work() {
+	defer u.workers.Done()
+
+	for job := range u.queue {
+		u.upload(job)
+		u.done()
+	}
+}
+
+// Enqueue submits a job for the background upload. It returns an error if the job
+// has been discarded, either because the uploader is closed or the queue is full
+// and the policy is QueuePolicyDrop.
+func (u *uploader) Enqueue(job *uploadJob) error {
+	u.queueMux.RLock()
+	defer u.queueMux.RUnlock()
+
+	if u.closed {
+		return errUploaderClosed
+	}
+
+	u.begin()
+
+	if u.policy == QueuePolicyDrop {
+		select {
+		case u.queue <- job:
+		default:
+			u.done()
+			atomic.AddUint64(&u.dropped, 1)
+
+			return errQueueFull
+		}
+
+		return nil
+	}
+
+	u.queue <- job
+
+	return nil
+}
+
+// Dropped returns the amount of jobs discarded due to a full queue.
+func (u *uploader) Dropped() uint64 {
+	return atomic.LoadUint64(&u.dropped)
+}
+
+// Flush waits until all enqueued jobs are processed or the context is done.
+func (u *uploader) Flush(ctx context.Context) error {
+	u.pendingMux.Lock()
+	if u.pending == 0 {
+		u.pendingMux.Unlock()
+		return nil
+	}
+
+	idle := make(chan struct{})
+	u.idle = append(u.idle, idle)
+	u.pendingMux.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting new jobs and waits for the workers to process
+// the remaining ones.
+func (u *uploader) Close() error {
+	u.queueMux.Lock()
+	if u.closed {
+		u.queueMux.Unlock()
+		return nil
+	}
+
+	u.closed = true
+	close(u.queue)
+	u.queueMux.Unlock()
+
+	u.workers.Wait()
+
+	return nil
+}
+
+func (u *uploader) begin() {
+	u.pendingMux.Lock()
+	u.pending++
+	u.pendingMux.Unlock()
+}
+
+func (u *uploader) done() {
+	u.pendingMux.Lock()
+	defer u.pendingMux.Unlock()
+
+	u.pending--
+	if u.pending > 0 {
+		return
+	}
+
+	for _, idle := range u.idle {
+		close(idle)
+	}
+
+	u.idle = nil
+}
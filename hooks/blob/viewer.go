@@ -0,0 +1,189 @@
+package blob
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ViewerOptions allows to set additional viewer options.
+type ViewerOptions struct {
+	// Env limits the index page to the blobs of the env, all blobs are listed if empty.
+	Env string
+	// Keys are used to open encrypted blobs.
+	Keys Keyring
+	// IndexPeriod is how far back the index page lists blobs, 24 hours by default.
+	IndexPeriod time.Duration
+}
+
+// DefaultViewerIndexPeriod is the default period of blobs listed on the index page.
+const DefaultViewerIndexPeriod = 24 * time.Hour
+
+type viewer struct {
+	store BlobStore
+	opt   *ViewerOptions
+}
+
+// NewViewerHandler returns an HTTP handler serving blobs of the store, so blob URLs
+// are viewable in a browser. GET /<key> renders the blob with JSON and HTML syntax
+// highlighted, GET /<key>?raw serves the blob as is, GET / lists recent blobs.
+// Set HookOptions.BlobViewerURL to the handler address to make logged URLs point to it.
+func NewViewerHandler(store BlobStore, opt *ViewerOptions) http.Handler {
+	if opt == nil {
+		opt = &ViewerOptions{}
+	}
+
+	if opt.IndexPeriod <= 0 {
+		opt.IndexPeriod = DefaultViewerIndexPeriod
+	}
+
+	return &viewer{
+		store: store,
+		opt:   opt,
+	}
+}
+
+func (v *viewer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.Trim(r.URL.Path, "/")
+	if len(key) == 0 {
+		v.serveIndex(w)
+		return
+	}
+
+	spec, err := v.store.GetObject(key)
+	if err == ErrNotFound {
+		http.Error(w, "blob not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	blob, err := DecodeBlob(spec, v.opt.Keys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, raw := r.URL.Query()["raw"]; raw {
+		// the blob may hold arbitrary HTML, so scripts are not allowed to run
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Type", blob.ContentType)
+		w.Write(blob.Data) //nolint:errcheck
+
+		return
+	}
+
+	ts, _ := BlobTime(key)
+
+	v.render(w, blobTemplate, map[string]interface{}{
+		"Key":         key,
+		"Time":        ts,
+		"ContentType": blob.ContentType,
+		"Size":        len(blob.Data),
+		"Meta":        spec.Meta,
+		"Body":        Highlight(blob),
+	})
+}
+
+func (v *viewer) serveIndex(w http.ResponseWriter) {
+	var (
+		blobs []*ObjectSpec
+		err   error
+	)
+
+	from := time.Now().Add(-v.opt.IndexPeriod)
+
+	if len(v.opt.Env) > 0 {
+		blobs, err = ListBlobs(v.store, v.opt.Env, from, time.Time{})
+	} else {
+		blobs, err = v.listAll(from)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	// newest first
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].Key[strings.LastIndexByte(blobs[i].Key, '/')+1:] >
+			blobs[j].Key[strings.LastIndexByte(blobs[j].Key, '/')+1:]
+	})
+
+	v.render(w, indexTemplate, map[string]interface{}{
+		"Env":   v.opt.Env,
+		"Blobs": blobs,
+	})
+}
+
+// listAll lists blobs of every env logged since the time.
+func (v *viewer) listAll(from time.Time) ([]*ObjectSpec, error) {
+	specs, err := v.store.ListObjects(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []*ObjectSpec
+
+	for _, spec := range specs {
+		if ts, ok := BlobTime(spec.Key); ok && !ts.Before(from) {
+			blobs = append(blobs, spec)
+		}
+	}
+
+	return blobs, nil
+}
+
+func (v *viewer) render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+const viewerStyle = `<style>
+body { font: 14px/1.4 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #24292e; }
+a { color: #0366d6; text-decoration: none; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 12px 2px 0; text-align: left; vertical-align: top; }
+pre { background: #f6f8fa; padding: 1em; overflow: auto; font: 13px/1.45 SFMono-Regular, Menlo, Consolas, monospace; }
+.key { color: #005cc5; } .str { color: #032f62; } .num { color: #e36209; }
+.lit { color: #d73a49; } .tag { color: #22863a; } .com { color: #6a737d; }
+</style>`
+
+//nolint:gochecknoglobals
+var blobTemplate = template.Must(template.New("blob").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Key}}</title>` + viewerStyle + `</head><body>
+<p><a href="../">&larr; blobs</a></p>
+<h3>{{.Key}}</h3>
+<table>
+{{if not .Time.IsZero}}<tr><th>Time</th><td>{{.Time.Format "2006-01-02 15:04:05.000 MST"}}</td></tr>{{end}}
+<tr><th>Type</th><td>{{.ContentType}}</td></tr>
+<tr><th>Size</th><td>{{.Size}} bytes</td></tr>
+{{range $name, $value := .Meta}}<tr><th>{{$name}}</th><td>{{$value}}</td></tr>
+{{end}}</table>
+<p><a href="?raw">raw</a></p>
+<pre>{{.Body}}</pre>
+</body></html>
+`))
+
+//nolint:gochecknoglobals
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Blobs</title>` + viewerStyle + `</head><body>
+<h3>Blobs{{with .Env}} of {{.}}{{end}}</h3>
+<table>
+{{range .Blobs}}<tr><td><a href="{{.Key}}">{{.Key}}</a></td><td>{{.Size}}</td></tr>
+{{else}}<tr><td>No blobs logged recently.</td></tr>
+{{end}}</table>
+</body></html>
+`))
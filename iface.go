@@ -72,6 +72,14 @@ type OutputterConfigurator interface {
 	CallerName() string
 }
 
+// ContextExtractorAdder is implemented by outputters that support per-outputter context extractors.
+type ContextExtractorAdder interface {
+	AddContextExtractor(fn ContextExtractor)
+}
+
+// Won't compile if ContextExtractorAdder can't be realized by the outputter.
+var _ ContextExtractorAdder = &outputter{}
+
 // Won't compile if StdLogger can't be realized by the outputter.
 var (
 	_ StdLogger = &outputter{}
@@ -0,0 +1,149 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LevelSplitWriter is an io.WriteCloser routing log entries by level, so errors could go to
+// stderr and the rest to stdout. NewOutputter and New recognize it and write every entry
+// into exactly one of the writers. Entries are written sequentially without buffering,
+// so entries of both writers keep the order they were logged in.
+type LevelSplitWriter struct {
+	// Out receives entries less severe than Level, e.g. info and debug entries.
+	Out io.Writer
+	// Err receives entries of Level and more severe ones.
+	Err io.Writer
+	// Level is the least severe level written into Err.
+	Level Level
+
+	mux sync.Mutex
+}
+
+// SplitByLevel returns a writer routing warning and more severe entries into errW
+// and the rest into out, e.g. SplitByLevel(os.Stdout, os.Stderr).
+func SplitByLevel(out, errW io.Writer) *LevelSplitWriter {
+	return &LevelSplitWriter{
+		Out:   out,
+		Err:   errW,
+		Level: WarnLevel,
+	}
+}
+
+// WriterForLevel returns the writer receiving entries of the level.
+func (w *LevelSplitWriter) WriterForLevel(level Level) io.Writer {
+	if level <= w.Level {
+		return w.Err
+	}
+
+	return w.Out
+}
+
+// WriteLevel writes the entry of the level into its writer.
+func (w *LevelSplitWriter) WriteLevel(level Level, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	return w.WriterForLevel(level).Write(p)
+}
+
+// Write writes into Out, as the level of the data is unknown.
+func (w *LevelSplitWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	return w.Out.Write(p)
+}
+
+// Close closes the writers that implement io.Closer, except stdout and stderr.
+func (w *LevelSplitWriter) Close() error {
+	var err error
+
+	var closed valueSet
+
+	for _, writer := range []io.Writer{w.Out, w.Err} {
+		closer, ok := writer.(io.Closer)
+		if !ok || writer == os.Stdout || writer == os.Stderr || !closed.Add(closer) {
+			continue
+		}
+
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// levelSplitFormatter formats entries and writes them into the writer of the entry level,
+// it's called under the logger lock, so the logger writes nothing.
+type levelSplitFormatter struct {
+	Formatter
+
+	w *LevelSplitWriter
+	// outLogger and errLogger are per writer, formatters get the writer
+	// from the entry logger, e.g. to check for a terminal
+	outLogger *logrus.Logger
+	errLogger *logrus.Logger
+}
+
+// withLevelSplit wraps the formatter if the output is a level-split writer,
+// or unwraps it if it has been wrapped for a previous output.
+func withLevelSplit(formatter Formatter, output io.Writer) Formatter {
+	if f, ok := formatter.(*levelSplitFormatter); ok {
+		formatter = f.Formatter
+	}
+
+	w, ok := output.(*LevelSplitWriter)
+	if !ok {
+		return formatter
+	}
+
+	return &levelSplitFormatter{
+		Formatter: formatter,
+		w:         w,
+		outLogger: newWriterLogger(w.Out, formatter),
+		errLogger: newWriterLogger(w.Err, formatter),
+	}
+}
+
+func newWriterLogger(w io.Writer, formatter Formatter) *logrus.Logger {
+	return &logrus.Logger{
+		Out:       w,
+		Formatter: formatter,
+		Hooks:     make(LevelHooks),
+		Level:     TraceLevel,
+	}
+}
+
+func (f *levelSplitFormatter) Format(entry *Entry) ([]byte, error) {
+	levelEntry := *entry
+	if entry.Level <= f.w.Level {
+		levelEntry.Logger = f.errLogger
+	} else {
+		levelEntry.Logger = f.outLogger
+	}
+
+	serialized, err := f.Formatter.Format(&levelEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.w.WriteLevel(entry.Level, serialized); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write to log, %v\n", err)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,112 @@
+package output
+
+import (
+	"strings"
+	"testing"
+)
+
+// streamWriter records lines written into a named stream in a shared log, so the order
+// of lines written into different streams could be checked.
+type streamWriter struct {
+	name string
+	log  *[]string
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSpace(string(p)), "\n") {
+		*w.log = append(*w.log, w.name+": "+line)
+	}
+
+	return len(p), nil
+}
+
+func TestLevelSplit(t *testing.T) {
+	var log []string
+
+	stdout := &streamWriter{name: "stdout", log: &log}
+	stderr := &streamWriter{name: "stderr", log: &log}
+
+	out := NewOutputter(SplitByLevel(stdout, stderr), &TextFormatter{
+		DisableTimestamp: true,
+	})
+
+	out.Debugln("loading")
+	out.Infoln("loaded")
+	out.Warningln("slow")
+	out.WithField("code", 1).Errorln("failed")
+	out.Infoln("retrying")
+
+	expected := []string{
+		`stdout: level=debug msg=loading`,
+		`stdout: level=info msg=loaded`,
+		`stderr: level=warning msg=slow`,
+		`stderr: level=error msg=failed code=1`,
+		`stdout: level=info msg=retrying`,
+	}
+
+	if strings.Join(log, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", strings.Join(log, "\n"), strings.Join(expected, "\n"))
+	}
+
+	log = nil
+
+	out.(OutputterConfigurator).SetFormatter(new(JSONFormatter))
+	out.Errorln("failed")
+
+	if len(log) != 1 || !strings.HasPrefix(log[0], `stderr: {`) {
+		t.Errorf("expected JSON entry written into stderr, got %q", log)
+	}
+}
+
+func TestLevelSplitClassic(t *testing.T) {
+	var log []string
+
+	stdout := &streamWriter{name: "stdout", log: &log}
+	stderr := &streamWriter{name: "stderr", log: &log}
+
+	l := New(SplitByLevel(stdout, stderr), nil)
+	l.Notification("building %s", "app")
+	l.Error("build failed")
+	l.Success("done")
+
+	if len(log) != 3 {
+		t.Fatalf("expected 3 lines, got %q", log)
+	}
+
+	for i, stream := range []string{"stdout", "stderr", "stdout"} {
+		if !strings.HasPrefix(log[i], stream+": ") {
+			t.Errorf("expected line %d written into %s, got %q", i, stream, log[i])
+		}
+	}
+}
+
+// taggedWriter is a writer of a type that can't be hashed or compared, as it has a slice field.
+type taggedWriter struct {
+	tags []string
+	log  *[]string
+}
+
+func (w taggedWriter) Write(p []byte) (int, error) {
+	*w.log = append(*w.log, strings.Join(w.tags, ",")+": "+strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+func TestLevelSplitUnhashableWriter(t *testing.T) {
+	var log []string
+
+	out := NewOutputter(SplitByLevel(taggedWriter{tags: []string{"out"}, log: &log}, taggedWriter{tags: []string{"err"}, log: &log}), &TextFormatter{
+		DisableTimestamp: true,
+	})
+
+	out.Infoln("loaded")
+	out.Errorln("failed")
+
+	expected := []string{
+		`out: level=info msg=loaded`,
+		`err: level=error msg=failed`,
+	}
+
+	if strings.Join(log, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", strings.Join(log, "\n"), strings.Join(expected, "\n"))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"os"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -17,7 +18,8 @@ import (
 	"github.com/xlab/closer"
 )
 
-// NewOutputter constructs a new outputter.
+// NewOutputter constructs a new outputter. If wc is a LevelSplitWriter,
+// entries are written into its writers by level.
 func NewOutputter(wc io.Writer, formatter Formatter, hooks ...Hook) Outputter {
 	if formatter == nil {
 		formatter = new(TextFormatter)
@@ -26,16 +28,17 @@ func NewOutputter(wc io.Writer, formatter Formatter, hooks ...Hook) Outputter {
 	out := &outputter{
 		logger: &logrus.Logger{
 			Out:       wc,
-			Formatter: formatter,
+			Formatter: withLevelSplit(formatter, wc),
 			Hooks:     make(LevelHooks),
 			Level:     DebugLevel,
 			ExitFunc:  closer.Exit,
 		},
 
-		wc:       wc,
-		mux:      new(sync.Mutex),
-		stack:    stackcache.New(1, "github.com/hatchify/output"),
-		initDone: true,
+		wc:         wc,
+		mux:        new(sync.Mutex),
+		stack:      stackcache.New(1, "github.com/hatchify/output"),
+		extractors: new(contextExtractors),
+		initDone:   true,
 	}
 	out.entry = out.logger.WithContext(context.Background())
 
@@ -54,6 +57,8 @@ type outputter struct {
 	wc    io.Writer
 	stack stackcache.StackCache
 
+	extractors *contextExtractors
+
 	init     sync.Once
 	initDone bool
 	closed   bool
@@ -82,6 +87,7 @@ func (out *outputter) initOnce() {
 		out.stack = stackcache.New(1, "github.com/hatchify/output")
 		out.addDefaultHooks()
 		out.mux = new(sync.Mutex)
+		out.extractors = new(contextExtractors)
 		out.initDone = true
 	})
 }
@@ -92,8 +98,11 @@ func (out *outputter) addDefaultHooks() {
 	out.logger.AddHook(debugHook.NewHook(nil))
 
 	if isTrue(os.Getenv("OUTPUT_BLOB_ENABLED")) {
-		hook, _ := blobHook.NewHook(nil)
-		out.logger.AddHook(hook)
+		if hook, err := blobHook.NewHook(nil); err != nil {
+			logrus.WithError(err).Warningln("failed to init blob hook")
+		} else {
+			out.logger.AddHook(hook)
+		}
 	}
 
 	if isTrue(os.Getenv("OUTPUT_BUGSNAG_ENABLED")) {
@@ -133,12 +142,21 @@ func (out *outputter) WithError(err error) Outputter {
 	return outCopy
 }
 
-// Add a context to the log entry.
+// Add a context to the log entry, along with the fields extracted from the context
+// by the registered context extractors.
 func (out *outputter) WithContext(ctx context.Context) Outputter {
 	out.initOnce()
 	outCopy := out.copy()
 	outCopy.entry = out.entry.WithContext(ctx)
 
+	if fields := out.extractContextFields(ctx); len(fields) > 0 {
+		for key := range out.entry.Data {
+			delete(fields, key)
+		}
+
+		outCopy.entry = outCopy.entry.WithFields(fields)
+	}
+
 	return outCopy
 }
 
@@ -189,11 +207,12 @@ func (out *outputter) Errorf(format string, args ...interface{}) {
 func (out *outputter) Fatalf(format string, args ...interface{}) {
 	out.initOnce()
 	out.entry.Logf(FatalLevel, format, args...)
-	out.logger.Exit(1)
+	out.exit(1)
 }
 
 func (out *outputter) Panicf(format string, args ...interface{}) {
 	out.initOnce()
+	defer out.flush()
 	out.entry.Logf(PanicLevel, format, args...)
 }
 
@@ -220,11 +239,12 @@ func (out *outputter) Print(args ...interface{}) {
 func (out *outputter) Fatal(args ...interface{}) {
 	out.initOnce()
 	out.entry.Log(FatalLevel, args...)
-	out.logger.Exit(1)
+	out.exit(1)
 }
 
 func (out *outputter) Panic(args ...interface{}) {
 	out.initOnce()
+	defer out.flush()
 	out.entry.Log(PanicLevel, args...)
 }
 
@@ -266,7 +286,7 @@ func (out *outputter) Errorln(args ...interface{}) {
 func (out *outputter) Fatalln(args ...interface{}) {
 	out.initOnce()
 	out.entry.Logln(FatalLevel, args...)
-	out.logger.Exit(1)
+	out.exit(1)
 }
 
 func (out *outputter) Debug(format string, args ...interface{}) {
@@ -296,6 +316,7 @@ func (out *outputter) Error(format string, args ...interface{}) {
 
 func (out *outputter) Panicln(args ...interface{}) {
 	out.initOnce()
+	defer out.flush()
 	out.entry.Logln(PanicLevel, args...)
 }
 
@@ -326,22 +347,31 @@ func (out *outputter) IsLevelEnabled(level Level) bool {
 // SetFormatter sets the logger formatter.
 func (out *outputter) SetFormatter(formatter Formatter) {
 	out.initOnce()
-	out.logger.SetFormatter(formatter)
+	out.logger.SetFormatter(withLevelSplit(formatter, out.logger.Out))
 }
 
 // SetOutput sets the logger output.
 func (out *outputter) SetOutput(output io.Writer) {
 	out.initOnce()
+	out.logger.SetFormatter(withLevelSplit(out.logger.Formatter, output))
 	out.logger.SetOutput(output)
 }
 
+// AddContextExtractor registers the extractor for the outputter and its copies,
+// its fields take precedence over the fields of the global extractors.
+func (out *outputter) AddContextExtractor(fn ContextExtractor) {
+	out.initOnce()
+	out.extractors.Add(fn)
+}
+
 // ReplaceHooks replaces the logger hooks and returns the old ones
 func (out *outputter) ReplaceHooks(hooks LevelHooks) LevelHooks {
 	out.initOnce()
 	return out.logger.ReplaceHooks(hooks)
 }
 
-// Close effectively closes output, closing the underlying writer
+// Close effectively closes output, draining the hooks that implement io.Closer
+// (e.g. blob hook with pending uploads) and closing the underlying writer
 // if it implements io.WriteCloser.
 func (out *outputter) Close() (err error) {
 	// bail out if already closed
@@ -354,6 +384,10 @@ func (out *outputter) Close() (err error) {
 
 	out.closed = true
 
+	if out.logger != nil {
+		closeHooks(out.logger.Hooks)
+	}
+
 	// try to close only WriteClosers
 	if outCloser, ok := out.wc.(io.WriteCloser); ok {
 		return outCloser.Close()
@@ -372,6 +406,149 @@ func (out *outputter) CallerName() string {
 	return nameParts[len(nameParts)-1]
 }
 
+// exitFlushTimeout limits how long Fatal and Panic wait for the hooks to flush.
+const exitFlushTimeout = 10 * time.Second
+
+// hookFlusher is implemented by hooks doing work in background, e.g. the blob hook.
+type hookFlusher interface {
+	Flush(ctx context.Context) error
+}
+
+// writerFlusher is implemented by buffering writers, e.g. AsyncWriter.
+type writerFlusher interface {
+	Flush() error
+}
+
+// exit flushes the writer and the hooks and exits, so e.g. buffered entries are
+// written and blobs of the fatal entry are uploaded rather than lost with the process.
+func (out *outputter) exit(code int) {
+	out.flush()
+	out.logger.Exit(code)
+}
+
+// flush waits for the writer and the hooks to finish background work of the logged
+// entries. It's called before exit and panic, as logging in background would be lost.
+func (out *outputter) flush() {
+	if flusher, ok := out.logger.Out.(writerFlusher); ok {
+		if err := flusher.Flush(); err != nil && err != os.ErrClosed {
+			logrus.WithError(err).Warningln("failed to flush output writer")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exitFlushTimeout)
+	defer cancel()
+
+	var flushed valueSet
+
+	for _, levelHooks := range out.logger.Hooks {
+		for _, h := range levelHooks {
+			flusher, ok := h.(hookFlusher)
+			if !ok || !flushed.Add(flusher) {
+				continue
+			}
+
+			if err := flusher.Flush(ctx); err != nil {
+				logrus.WithError(err).Warningln("failed to flush output hook")
+			}
+		}
+	}
+}
+
+// closeHooks closes every distinct hook that implements io.Closer,
+// the hooks are usually registered for multiple levels.
+func closeHooks(hooks LevelHooks) {
+	var closed valueSet
+
+	for _, levelHooks := range hooks {
+		for _, h := range levelHooks {
+			hookCloser, ok := h.(io.Closer)
+			if !ok || !closed.Add(hookCloser) {
+				continue
+			}
+
+			if err := hookCloser.Close(); err != nil {
+				logrus.WithError(err).Warningln("failed to close output hook")
+			}
+		}
+	}
+}
+
+// valueSet is a set of distinct values, e.g. hooks. Values could hold slices, maps or
+// funcs, directly or in interface fields, so comparing them with == may panic and
+// using them as map keys too. Such values are compared by their contents instead,
+// see sameValue.
+type valueSet []interface{}
+
+// Add adds the value to the set, it returns false if the value is already there.
+func (s *valueSet) Add(c interface{}) bool {
+	for _, added := range *s {
+		if sameValue(reflect.ValueOf(added), reflect.ValueOf(c)) {
+			return false
+		}
+	}
+
+	*s = append(*s, c)
+
+	return true
+}
+
+// sameValue reports whether the values are the same, it never panics. Values are compared
+// like with ==, and slices, maps and funcs, which are not comparable, are compared by their
+// pointers, funcs by code pointers. So copies of a value are the same, e.g. a hook added for
+// multiple levels.
+func sameValue(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a.Kind() {
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() == b.Float()
+	case reflect.Complex64, reflect.Complex128:
+		return a.Complex() == b.Complex()
+	case reflect.String:
+		return a.String() == b.String()
+	case reflect.Slice:
+		return a.Pointer() == b.Pointer() && a.Len() == b.Len()
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return a.Pointer() == b.Pointer()
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+
+		return sameValue(a.Elem(), b.Elem())
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if !sameValue(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+
+		return true
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !sameValue(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
 func isTrue(v string) bool {
 	switch strings.ToLower(v) {
 	case "1", "true", "y":
@@ -384,11 +561,12 @@ func isTrue(v string) bool {
 // copy allows to construct an outputter copy with new entry.
 func (out *outputter) copy() *outputter {
 	return &outputter{
-		wc:       out.wc,
-		logger:   out.logger,
-		stack:    out.stack,
-		mux:      out.mux,
-		initDone: out.initDone,
-		closed:   out.closed,
+		wc:         out.wc,
+		logger:     out.logger,
+		stack:      out.stack,
+		mux:        out.mux,
+		extractors: out.extractors,
+		initDone:   out.initDone,
+		closed:     out.closed,
 	}
 }
@@ -0,0 +1,171 @@
+package output
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// valueHook is a hook of a non-comparable type, which can't be a map key.
+type valueHook struct {
+	levels []Level
+	closed *int
+}
+
+func (h valueHook) Levels() []Level {
+	return h.levels
+}
+
+func (h valueHook) Fire(e *Entry) error {
+	return nil
+}
+
+func (h valueHook) Close() error {
+	*h.closed++
+	return nil
+}
+
+func TestCloseHooks(t *testing.T) {
+	var closed int
+
+	hooks := make(LevelHooks)
+	hooks.Add(valueHook{
+		levels: logrus.AllLevels,
+		closed: &closed,
+	})
+
+	closeHooks(hooks)
+
+	if closed != 1 {
+		t.Errorf("expected the hook closed once, closed %d times", closed)
+	}
+}
+
+// writerHook is a hook of a comparable type holding a writer, comparing such hooks
+// with == panics if the writer is not comparable.
+type writerHook struct {
+	w      io.Writer
+	closed *int
+}
+
+func (h writerHook) Levels() []Level {
+	return logrus.AllLevels
+}
+
+func (h writerHook) Fire(e *Entry) error {
+	return nil
+}
+
+func (h writerHook) Close() error {
+	*h.closed++
+	return nil
+}
+
+// funcHook is a hook with a func field, such hooks are never reflect.DeepEqual.
+type funcHook struct {
+	levels []Level
+	fire   func(e *Entry) error
+	closed *int
+}
+
+func (h funcHook) Levels() []Level {
+	return h.levels
+}
+
+func (h funcHook) Fire(e *Entry) error {
+	return h.fire(e)
+}
+
+func (h funcHook) Close() error {
+	*h.closed++
+	return nil
+}
+
+func TestCloseHooksDynamic(t *testing.T) {
+	var log []string
+
+	var writerClosed, funcClosed, otherClosed int
+
+	fire := func(e *Entry) error {
+		return nil
+	}
+
+	hooks := make(LevelHooks)
+	hooks.Add(writerHook{
+		w:      taggedWriter{tags: []string{"hook"}, log: &log},
+		closed: &writerClosed,
+	})
+	hooks.Add(funcHook{
+		levels: logrus.AllLevels,
+		fire:   fire,
+		closed: &funcClosed,
+	})
+	hooks.Add(funcHook{
+		levels: logrus.AllLevels,
+		fire:   fire,
+		closed: &otherClosed,
+	})
+
+	closeHooks(hooks)
+
+	for name, closed := range map[string]int{
+		"writer": writerClosed,
+		"func":   funcClosed,
+		"other":  otherClosed,
+	} {
+		if closed != 1 {
+			t.Errorf("expected the %s hook closed once, closed %d times", name, closed)
+		}
+	}
+}
+
+// flushingHook records whether it has been flushed.
+type flushingHook struct {
+	countingHook
+
+	flushed int
+}
+
+func (h *flushingHook) Flush(ctx context.Context) error {
+	h.flushed++
+	return nil
+}
+
+func TestFatalFlush(t *testing.T) {
+	hook := new(flushingHook)
+
+	out := NewOutputter(ioutil.Discard, nil, hook)
+
+	var exitCode int
+
+	out.(*outputter).logger.ExitFunc = func(code int) {
+		exitCode = code
+	}
+
+	out.WithField("reason", "test").Fatalln("fatal reason")
+
+	if exitCode != 1 {
+		t.Errorf("expected exit code 1, got %d", exitCode)
+	}
+
+	if hook.fired != 1 || hook.flushed != 1 {
+		t.Errorf("expected hook fired and flushed before exit, fired %d, flushed %d", hook.fired, hook.flushed)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected Panic to panic")
+			}
+		}()
+
+		out.Panicf("panic %d", 1)
+	}()
+
+	if hook.flushed != 2 {
+		t.Errorf("expected hook flushed before panic, flushed %d times", hook.flushed)
+	}
+}
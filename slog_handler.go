@@ -0,0 +1,155 @@
+//go:build go1.21
+// +build go1.21
+
+package output
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// SlogHandler is a log/slog handler writing records through an outputter, so slog
+// and output share hooks and the formatter. Attributes become entry fields, the keys
+// of grouped attributes are qualified by the group names, e.g. "http.method".
+type SlogHandler struct {
+	out    Outputter
+	groups []string
+}
+
+// Ensure that SlogHandler implements slog.Handler during the compilation phase.
+var _ slog.Handler = &SlogHandler{}
+
+// NewSlogHandler returns a handler writing into the outputter, e.g.
+// slog.New(output.NewSlogHandler(out)). The default outputter is used if out is nil.
+func NewSlogHandler(out Outputter) *SlogHandler {
+	if out == nil {
+		out = defaultOut
+	}
+
+	return &SlogHandler{
+		out: out,
+	}
+}
+
+// SlogLevel maps the slog level onto the closest outputter level, levels below
+// slog.LevelDebug are mapped to TraceLevel.
+func SlogLevel(level slog.Level) Level {
+	switch {
+	case level >= slog.LevelError:
+		return ErrorLevel
+	case level >= slog.LevelWarn:
+		return WarnLevel
+	case level >= slog.LevelInfo:
+		return InfoLevel
+	case level >= slog.LevelDebug:
+		return DebugLevel
+	default:
+		return TraceLevel
+	}
+}
+
+// Enabled reports whether the outputter logs records of the level.
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if c, ok := h.out.(interface{ IsLevelEnabled(Level) bool }); ok {
+		return c.IsLevelEnabled(SlogLevel(level))
+	}
+
+	return true
+}
+
+// Handle writes the record with its attributes and context, so context extractors apply.
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := h.out
+
+	if ctx != nil {
+		out = out.WithContext(ctx)
+	}
+
+	if !r.Time.IsZero() {
+		out = out.WithTime(r.Time)
+	}
+
+	if r.NumAttrs() > 0 {
+		fields := make(Fields, r.NumAttrs())
+		prefix := h.prefix()
+
+		r.Attrs(func(a slog.Attr) bool {
+			addSlogAttr(fields, prefix, a)
+			return true
+		})
+
+		out = out.WithFields(fields)
+	}
+
+	out.Log(SlogLevel(r.Level), r.Message)
+
+	return nil
+}
+
+// WithAttrs returns a handler adding the attributes to every record.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	fields := make(Fields, len(attrs))
+	prefix := h.prefix()
+
+	for _, a := range attrs {
+		addSlogAttr(fields, prefix, a)
+	}
+
+	return &SlogHandler{
+		out:    h.out.WithFields(fields),
+		groups: h.groups,
+	}
+}
+
+// WithGroup returns a handler qualifying keys of the attributes added later by the group name.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if len(name) == 0 {
+		return h
+	}
+
+	groups := make([]string, len(h.groups), len(h.groups)+1)
+	copy(groups, h.groups)
+
+	return &SlogHandler{
+		out:    h.out,
+		groups: append(groups, name),
+	}
+}
+
+func (h *SlogHandler) prefix() string {
+	if len(h.groups) == 0 {
+		return ""
+	}
+
+	return strings.Join(h.groups, ".") + "."
+}
+
+// addSlogAttr adds the attribute to fields, group attributes are flattened into
+// fields with qualified keys. Empty attributes are skipped, as slog handlers should.
+func addSlogAttr(fields Fields, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if len(a.Key) > 0 {
+			// attributes of groups without a key are inlined
+			groupPrefix += a.Key + "."
+		}
+
+		for _, groupAttr := range a.Value.Group() {
+			addSlogAttr(fields, groupPrefix, groupAttr)
+		}
+
+		return
+	}
+
+	fields[prefix+a.Key] = a.Value.Any()
+}
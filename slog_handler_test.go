@@ -0,0 +1,90 @@
+//go:build go1.21
+// +build go1.21
+
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlogHandler(t *testing.T) {
+	var buf bytes.Buffer
+
+	hook := new(countingHook)
+	out := NewOutputter(&buf, new(JSONFormatter), hook)
+	out.(OutputterConfigurator).SetLevel(InfoLevel)
+
+	ts := time.Date(2020, 6, 1, 15, 0, 0, 0, time.UTC)
+	logger := slog.New(NewSlogHandler(out)).With("service", "api").WithGroup("http")
+
+	logger.Debug("skipped")
+
+	r := slog.NewRecord(ts, slog.LevelWarn, "slow request", 0)
+	r.AddAttrs(
+		slog.String("method", "GET"),
+		slog.Group("response", slog.Int("status", 200), slog.Duration("took", time.Second)),
+		slog.Group("", slog.String("route", "/users")),
+		slog.Attr{},
+	)
+
+	if err := logger.Handler().Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %q", buf.String())
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"level":                "warning",
+		"msg":                  "slow request",
+		"time":                 ts.Local().Format(time.RFC3339),
+		"service":              "api",
+		"http.method":          "GET",
+		"http.response.status": float64(200),
+		"http.response.took":   float64(time.Second),
+		"http.route":           "/users",
+	}
+
+	for key, v := range expected {
+		if entry[key] != v {
+			t.Errorf("expected %s=%v, got %v", key, v, entry[key])
+		}
+	}
+
+	if len(entry) != len(expected) {
+		t.Errorf("unexpected fields: %v", entry)
+	}
+
+	if hook.fired != 1 {
+		t.Errorf("expected hook to fire once, fired %d times", hook.fired)
+	}
+}
+
+func TestSlogLevel(t *testing.T) {
+	for level, expected := range map[slog.Level]Level{
+		slog.LevelDebug - 4: TraceLevel,
+		slog.LevelDebug:     DebugLevel,
+		slog.LevelInfo:      InfoLevel,
+		slog.LevelInfo + 2:  InfoLevel,
+		slog.LevelWarn:      WarnLevel,
+		slog.LevelError:     ErrorLevel,
+		slog.LevelError + 4: ErrorLevel,
+	} {
+		if SlogLevel(level) != expected {
+			t.Errorf("expected %v mapped to %v, got %v", level, expected, SlogLevel(level))
+		}
+	}
+}
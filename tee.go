@@ -0,0 +1,172 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sink is a destination of log entries written by a tee outputter.
+type Sink struct {
+	// Writer receives formatted entries, os.Stderr by default.
+	Writer io.Writer
+	// Formatter formats entries for the sink, TextFormatter by default.
+	Formatter Formatter
+	// Level is the least severe level written to the sink, e.g. InfoLevel
+	// skips debug and trace entries. Like logger levels, it has to be set.
+	Level Level
+	// FieldFilter reports whether the field is written to the sink,
+	// all fields are written if it's nil.
+	FieldFilter func(key string) bool
+}
+
+// ExcludeFields returns a sink field filter that skips the fields.
+func ExcludeFields(keys ...string) func(key string) bool {
+	excluded := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		excluded[key] = true
+	}
+
+	return func(key string) bool {
+		return !excluded[key]
+	}
+}
+
+// NewTee constructs a new outputter writing every entry into multiple sinks,
+// each having its own writer, formatter, level and field filter. Hooks fire
+// once per entry, before the entry is written into the sinks. Close closes
+// writers of the sinks, Fatal and Panic flush them.
+func NewTee(sinks []Sink, hooks ...Hook) Outputter {
+	t := &tee{
+		sinks: make([]*teeSink, 0, len(sinks)),
+	}
+
+	level := PanicLevel
+
+	for _, s := range sinks {
+		if s.Writer == nil {
+			s.Writer = os.Stderr
+		}
+
+		if s.Formatter == nil {
+			s.Formatter = new(TextFormatter)
+		}
+
+		if s.Level > level {
+			level = s.Level
+		}
+
+		t.sinks = append(t.sinks, &teeSink{
+			Sink: s,
+			// formatters get the sink writer from the entry logger, e.g. to check for a terminal
+			logger: &logrus.Logger{
+				Out:       s.Writer,
+				Formatter: s.Formatter,
+				Hooks:     make(LevelHooks),
+				Level:     s.Level,
+			},
+		})
+	}
+
+	out := NewOutputter(t, t, hooks...).(*outputter)
+	out.logger.SetLevel(level)
+
+	return out
+}
+
+type teeSink struct {
+	Sink
+
+	logger *logrus.Logger
+}
+
+// tee is both the formatter and the writer of the outputter logger,
+// it formats and writes the entry into every sink, so the logger writes nothing.
+type tee struct {
+	sinks []*teeSink
+}
+
+// Format writes the entry into the sinks. It is called under the logger lock,
+// so the sinks are written sequentially.
+func (t *tee) Format(entry *Entry) ([]byte, error) {
+	for _, s := range t.sinks {
+		if entry.Level > s.Level {
+			continue
+		}
+
+		sinkEntry := *entry
+		sinkEntry.Logger = s.logger
+		// the entry buffer is shared, formatters append to it
+		sinkEntry.Buffer = nil
+
+		if s.FieldFilter != nil {
+			sinkEntry.Data = make(Fields, len(entry.Data))
+
+			for key, v := range entry.Data {
+				if s.FieldFilter(key) {
+					sinkEntry.Data[key] = v
+				}
+			}
+		}
+
+		serialized, err := s.Formatter.Format(&sinkEntry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to obtain reader, %v\n", err)
+			continue
+		}
+
+		if _, err := s.Writer.Write(serialized); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write to log sink, %v\n", err)
+		}
+	}
+
+	return nil, nil
+}
+
+// Write discards the output of Format, which is empty.
+func (t *tee) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Flush flushes writers of the sinks that buffer entries, e.g. AsyncWriter.
+// It's called by the outputter before Fatal exits.
+func (t *tee) Flush() error {
+	var err error
+
+	var flushed valueSet
+
+	for _, s := range t.sinks {
+		flusher, ok := s.Writer.(writerFlusher)
+		if !ok || !flushed.Add(flusher) {
+			continue
+		}
+
+		if flushErr := flusher.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+
+	return err
+}
+
+// Close closes writers of the sinks, each writer is closed once.
+func (t *tee) Close() error {
+	var err error
+
+	var closed valueSet
+
+	for _, s := range t.sinks {
+		closer, ok := s.Writer.(io.Closer)
+		if !ok || s.Writer == os.Stdout || s.Writer == os.Stderr || !closed.Add(closer) {
+			continue
+		}
+
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
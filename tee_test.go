@@ -0,0 +1,149 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type countingHook struct {
+	fired int
+}
+
+func (h *countingHook) Levels() []Level {
+	return logrus.AllLevels
+}
+
+func (h *countingHook) Fire(e *Entry) error {
+	h.fired++
+	return nil
+}
+
+type closingBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closingBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestTee(t *testing.T) {
+	var terminal bytes.Buffer
+
+	file := new(closingBuffer)
+	hook := new(countingHook)
+
+	out := NewTee([]Sink{{
+		Writer: &terminal,
+		Formatter: &TextFormatter{
+			DisableTimestamp: true,
+		},
+		Level:       InfoLevel,
+		FieldFilter: ExcludeFields("request"),
+	}, {
+		Writer:    file,
+		Formatter: new(JSONFormatter),
+		Level:     DebugLevel,
+	}}, hook)
+
+	out.WithField("request", "GET /").Debugln("request received")
+	out.WithField("request", "GET /").Infoln("request done")
+
+	if hook.fired != 2 {
+		t.Errorf("expected hook to fire once per entry, fired %d times", hook.fired)
+	}
+
+	if text := terminal.String(); text != "level=info msg=\"request done\"\n" {
+		t.Errorf("unexpected terminal sink output: %q", text)
+	}
+
+	lines := strings.Split(strings.TrimSpace(file.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 entries in file sink, got %d", len(lines))
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	} else if entry["request"] != "GET /" || entry["level"] != "debug" {
+		t.Errorf("unexpected file sink entry: %v", entry)
+	}
+
+	if err := out.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	} else if !file.closed {
+		t.Error("expected Close to close sink writers")
+	}
+}
+
+func TestTeeFatal(t *testing.T) {
+	w := new(slowWriter)
+
+	out := NewTee([]Sink{{
+		Writer:    NewAsyncWriter(w, nil),
+		Formatter: new(JSONFormatter),
+		Level:     InfoLevel,
+	}})
+
+	out.(*outputter).logger.ExitFunc = func(code int) {}
+	out.Fatalln("fatal reason")
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if !strings.Contains(w.buf.String(), "fatal reason") {
+		t.Errorf("expected sink flushed before exit, got %q", w.buf.String())
+	}
+}
+
+// closingWriter is a writer of a comparable type holding a writer, comparing such writers
+// with == panics if the held writer is not comparable.
+type closingWriter struct {
+	w      io.Writer
+	closed *int
+}
+
+func (w closingWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w closingWriter) Close() error {
+	*w.closed++
+	return nil
+}
+
+func TestTeeCloseDynamic(t *testing.T) {
+	var log []string
+
+	var closed int
+
+	w := closingWriter{
+		w:      taggedWriter{tags: []string{"sink"}, log: &log},
+		closed: &closed,
+	}
+
+	out := NewTee([]Sink{
+		{Writer: w, Level: InfoLevel},
+		{Writer: w, Formatter: new(JSONFormatter), Level: ErrorLevel},
+	})
+
+	out.Errorln("failed")
+
+	if len(log) != 2 {
+		t.Errorf("expected the entry written by both sinks, got %q", log)
+	}
+
+	if err := out.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if closed != 1 {
+		t.Errorf("expected the writer closed once, closed %d times", closed)
+	}
+}